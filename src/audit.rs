@@ -0,0 +1,46 @@
+use std::{
+    fs::File,
+    io::{self, LineWriter, Write},
+    path::Path,
+    sync::Mutex,
+};
+
+use serde_json::{json, Value};
+
+/// Trace of every decision a run bases its result on: the requests it
+/// sends, which links of each article are kept and why the others aren't,
+/// and the order articles are queued and expanded in.
+///
+/// Records are JSON objects, one per line, with their `kind` and a `seq`
+/// number counting them in the order they were made. They hold no times
+/// but those of requests, so the traces of two runs can be diffed.
+pub struct Audit {
+    out: Mutex<Option<(u64, LineWriter<File>)>>,
+}
+
+impl Audit {
+    pub fn create(path: &Path) -> io::Result<Self> {
+        Ok(Audit {
+            out: Mutex::new(Some((0, LineWriter::new(File::create(path)?)))),
+        })
+    }
+
+    /// Write a record of kind `kind` with the fields of `data`
+    pub fn record(&self, kind: &str, data: Value) {
+        let mut out = self.out.lock().unwrap();
+        let Some((seq, w)) = out.as_mut() else {
+            return;
+        };
+
+        *seq += 1;
+        let mut line = json!({ "seq": *seq, "kind": kind });
+        if let (Some(line), Value::Object(data)) = (line.as_object_mut(), data) {
+            line.extend(data);
+        }
+
+        if let Err(err) = writeln!(w, "{}", line) {
+            eprintln!("Audit log: {}", err);
+            *out = None;
+        }
+    }
+}
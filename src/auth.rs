@@ -0,0 +1,124 @@
+use std::{
+    fs,
+    io::{self, Write},
+    path::PathBuf,
+};
+
+use keyring::Entry;
+
+use crate::{quota, wiki::Error};
+
+/// Keyring entry the token is stored under
+const SERVICE: &str = "wiki-path";
+const USER: &str = "api-token";
+
+/// What to do with the stored token
+#[derive(clap::Subcommand, Debug)]
+pub enum Action {
+    /// Store a personal API token, read from stdin, in the system keyring
+    Login,
+    /// Remove the stored token
+    Logout,
+    /// Tell whether a token is stored
+    Status,
+}
+
+pub fn run(action: &Action) {
+    let result = match action {
+        Action::Login => login(),
+        Action::Logout => logout(),
+        Action::Status => status(),
+    };
+    if let Err(err) = result {
+        eprintln!("{}", err);
+    }
+}
+
+/// File in the cache directory telling that a token was stored, so a
+/// keyring that can't be read is only worth a warning if one was
+fn marker() -> Option<PathBuf> {
+    Some(quota::cache_dir()?.join("token-stored"))
+}
+
+/// The keyring entry of the token
+fn entry() -> Result<Entry, Error> {
+    Entry::new(SERVICE, USER).map_err(|err| format!("Keyring: {}", err).into())
+}
+
+fn login() -> Result<(), Error> {
+    // Not hidden, but kept out of the shell history and the process list
+    eprint!("Personal API token: ");
+    io::stderr().flush().ok();
+    let mut token = String::new();
+    io::stdin().read_line(&mut token)?;
+    let token = token.trim();
+    if token.is_empty() {
+        return Err("no token given".into());
+    }
+
+    entry()?
+        .set_password(token)
+        .map_err(|err| format!("Keyring: {}", err))?;
+    if let Some(marker) = marker() {
+        fs::create_dir_all(marker.parent().unwrap_or(&marker))
+            .and_then(|()| fs::write(&marker, ""))
+            .ok();
+    }
+    println!("Token stored in the system keyring");
+    Ok(())
+}
+
+fn logout() -> Result<(), Error> {
+    if let Some(marker) = marker() {
+        fs::remove_file(marker).ok();
+    }
+    match entry()?.delete_credential() {
+        Ok(()) => println!("Token removed"),
+        Err(keyring::Error::NoEntry) => println!("No token is stored"),
+        Err(err) => return Err(format!("Keyring: {}", err).into()),
+    }
+    Ok(())
+}
+
+fn status() -> Result<(), Error> {
+    match entry()?.get_password() {
+        Ok(token) => println!("A token is stored (quota account {})", account(&token)),
+        Err(keyring::Error::NoEntry) => println!("No token is stored, requests are anonymous"),
+        Err(err) => return Err(format!("Keyring: {}", err).into()),
+    }
+    Ok(())
+}
+
+/// The stored token, if any. A keyring that can't be read is only
+/// reported if a token was stored, or with `verbose`.
+pub fn token(verbose: bool) -> Option<String> {
+    let warn = verbose || marker().is_some_and(|marker| marker.exists());
+    let token = entry().and_then(|entry| match entry.get_password() {
+        Ok(token) => Ok(Some(token)),
+        Err(keyring::Error::NoEntry) => Ok(None),
+        Err(err) => Err(format!("Keyring: {}", err).into()),
+    });
+
+    match token {
+        Ok(token) => token,
+        Err(err) => {
+            if warn {
+                eprintln!("{}, requests are anonymous", err);
+            }
+            None
+        }
+    }
+}
+
+/// Name the requests made with `token` are counted under, the same across
+/// runs without giving the token away
+pub fn account(token: &str) -> String {
+    // FNV-1a, as the hashers of std are seeded differently in every run
+    let mut hash: u64 = 0xcbf29ce484222325;
+    for b in token.bytes() {
+        hash ^= b as u64;
+        hash = hash.wrapping_mul(0x100000001b3);
+    }
+
+    format!("token-{:08x}", hash >> 32)
+}
@@ -0,0 +1,117 @@
+use std::{
+    fs,
+    path::Path,
+    sync::atomic::{AtomicUsize, Ordering},
+    thread,
+    time::Instant,
+};
+
+use crate::{events::Events, history, search, title, wiki::Wiki};
+
+/// Search every start/end pair listed in `file`, `jobs` at a time.
+///
+/// The searches share the wiki's rate limit and link cache, so together they
+/// never go faster than a single search and a hub page reached by several of
+/// them is only fetched once.
+pub fn run(wiki: &mut Wiki, events: &Events, opts: &search::Options, file: &Path, jobs: usize) {
+    let pairs = match read_pairs(file) {
+        Ok(pairs) => pairs,
+        Err(err) => {
+            eprintln!("{}", err);
+            return;
+        }
+    };
+
+    wiki.enable_cache();
+    let wiki = &*wiki;
+
+    let opts = search::Options {
+        all: false,
+        record_edges: false,
+        ..*opts
+    };
+
+    let next = AtomicUsize::new(0);
+    thread::scope(|s| {
+        for _ in 0..jobs.min(pairs.len()) {
+            s.spawn(|| loop {
+                let Some((start, end)) = pairs.get(next.fetch_add(1, Ordering::Relaxed)) else {
+                    break;
+                };
+                let (start, end) = match (start.resolve(wiki), end.resolve(wiki)) {
+                    (Ok(start), Ok(end)) => (start, end),
+                    (Err(err), _) | (_, Err(err)) => {
+                        eprintln!("{}", err);
+                        continue;
+                    }
+                };
+
+                let start_time = Instant::now();
+                let mut found = None;
+                let explored = search::bfs(
+                    wiki,
+                    events,
+                    &opts,
+                    &start,
+                    Some(&search::Target::new(&end)),
+                    |path| {
+                        found = Some(path.clone());
+                    },
+                );
+                let elapsed =
+                    jiff::SignedDuration::from_secs_f64(start_time.elapsed().as_secs_f64());
+                if opts.history {
+                    history::record(&history::Search::bfs(
+                        wiki.project(),
+                        &start,
+                        &end,
+                        &explored,
+                        found.clone(),
+                        start_time.elapsed(),
+                    ));
+                }
+
+                match found {
+                    Some(path) => println!(
+                        "{} -> {}: {:?}, length {}, took {elapsed:#}",
+                        start,
+                        end,
+                        path,
+                        path.len()
+                    ),
+                    None => println!("{} -> {}: no path, took {elapsed:#}", start, end),
+                }
+            });
+        }
+    });
+}
+
+/// Read one whitespace-separated start/end pair per line, skipping blank
+/// lines and `#` comments
+pub fn read_pairs(file: &Path) -> Result<Vec<(title::Arg, title::Arg)>, String> {
+    let content = fs::read_to_string(file).map_err(|err| format!("{}: {}", file.display(), err))?;
+
+    let mut pairs = Vec::new();
+    for (n, line) in content.lines().enumerate() {
+        let line = line.trim();
+        if line.is_empty() || line.starts_with('#') {
+            continue;
+        }
+
+        let arg = |arg| {
+            title::parse_arg(arg).map_err(|err| format!("{}:{}: {}", file.display(), n + 1, err))
+        };
+        match line.split_whitespace().collect::<Vec<_>>()[..] {
+            [start, end] => pairs.push((arg(start)?, arg(end)?)),
+            _ => {
+                return Err(format!(
+                    "{}:{}: expected a start and an end article",
+                    file.display(),
+                    n + 1
+                ))
+            }
+        }
+    }
+
+    Ok(pairs)
+}
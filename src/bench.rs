@@ -0,0 +1,112 @@
+use std::{path::Path, time::Instant};
+
+use clap::ValueEnum;
+
+use crate::{batch, events::Events, heuristic::Strategy, search, title, wiki::Wiki};
+
+/// Start/end pairs searched by `bench`, cheapest first
+const PAIRS: &[(&str, &str)] = &[
+    ("Teletubbies", "Hamburg"),
+    ("Albert_Einstein", "Physics"),
+    ("Rust_(programming_language)", "Linux"),
+    ("Teletubbies", "Adolf_Hitler"),
+];
+
+/// Pairs of the demo wiki searched with `--demo`
+const DEMO_PAIRS: &[(&str, &str)] = &[("Cat", "Philosophy"), ("Wolf", "DNA"), ("Dog", "Water")];
+
+/// Search each pair with every strategy, each fetching at most `budget`
+/// articles. The pairs are read from `file` if given, as for `batch`, and
+/// are fixed otherwise.
+pub fn run(
+    wiki: &Wiki,
+    events: &Events,
+    opts: &search::Options,
+    demo: bool,
+    file: Option<&Path>,
+    budget: usize,
+) {
+    let pairs = match file {
+        Some(file) => match batch::read_pairs(file) {
+            Ok(pairs) => pairs,
+            Err(err) => {
+                eprintln!("{}", err);
+                return;
+            }
+        },
+        None => {
+            let pairs = if demo { DEMO_PAIRS } else { PAIRS };
+            pairs
+                .iter()
+                .map(|&(start, end)| {
+                    let arg = |title: &str| title::Arg::Title(title.to_string());
+                    (arg(start), arg(end))
+                })
+                .collect()
+        }
+    };
+
+    let opts = search::Options {
+        all: false,
+        record_edges: false,
+        budget: Some(budget),
+        ..*opts
+    };
+
+    println!(
+        "{:<50} {:<10} {:>6} {:>8} {:>8}  Time",
+        "Pair", "Strategy", "Length", "Requests", "Expanded"
+    );
+
+    for (start, end) in &pairs {
+        let (start, end) = match (start.resolve(wiki), end.resolve(wiki)) {
+            (Ok(start), Ok(end)) => (start, end),
+            (Err(err), _) | (_, Err(err)) => {
+                eprintln!("{}", err);
+                continue;
+            }
+        };
+        for &strategy in Strategy::value_variants() {
+            // Only with word vectors or a plugin to go on
+            if strategy == Strategy::Embeddings && opts.embeddings.is_none() {
+                continue;
+            }
+            if strategy == Strategy::Plugin && !opts.plugin.is_some_and(|p| p.has("score")) {
+                continue;
+            }
+
+            let requests = wiki.timings().requests;
+            let start_time = Instant::now();
+
+            let mut length = None;
+            let explored = search::search(
+                wiki,
+                events,
+                &opts,
+                strategy,
+                &start,
+                &search::Target::new(&end),
+                |path| {
+                    length = Some(path.len());
+                },
+            );
+            let explored = match explored {
+                Ok(explored) => explored,
+                Err(err) => {
+                    eprintln!("{}", err);
+                    continue;
+                }
+            };
+
+            let elapsed = jiff::SignedDuration::from_secs_f64(start_time.elapsed().as_secs_f64());
+            println!(
+                "{:<50} {:<10} {:>6} {:>8} {:>8}  {elapsed:#}",
+                format!("{start} -> {end}"),
+                strategy.name(),
+                length.map_or("-".to_string(), |l| l.to_string()),
+                wiki.timings().requests - requests,
+                explored.expanded,
+            );
+        }
+    }
+}
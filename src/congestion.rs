@@ -0,0 +1,137 @@
+use std::{
+    sync::{Condvar, Mutex},
+    thread,
+    time::{Duration, Instant},
+};
+
+/// Most requests a lane has in flight at once
+pub const MAX_IN_FLIGHT: usize = 8;
+/// Latency above this many times the usual one counts as the server
+/// slowing down
+const SLOW: f64 = 2.0;
+/// Weight of each response's latency in the usual one
+const SMOOTHING: f64 = 0.125;
+
+/// Additive-increase, multiplicative-decrease control of how many requests
+/// are in flight at once, within a rate limit.
+///
+/// Requests are sent at least `gap` apart. While that leaves the window of
+/// requests in flight full, the window grows by one for every window's
+/// worth of responses that come back fine and in the usual time; a slow
+/// response, an error or HTTP 429 or 5xx halves it, once per usual
+/// latency. Each request only holds the lock to take its turn, and waits
+/// for it without.
+pub struct Controller {
+    gap: Duration,
+    state: Mutex<State>,
+    /// Signalled when a request leaves the window
+    freed: Condvar,
+}
+
+struct State {
+    /// Requests allowed in flight; it grows by fractions as responses come
+    window: f64,
+    in_flight: usize,
+    /// When the next request may be sent
+    next_turn: Instant,
+    /// Smoothed latency of the responses so far
+    usual: Option<Duration>,
+    /// When the window was last halved
+    cut_at: Option<Instant>,
+}
+
+/// A request's place in the window, to be given back with its outcome
+pub struct Ticket {
+    /// Time waited for room in the window and for the request's turn
+    pub wait: Duration,
+    /// When the request was sent
+    pub sent: Instant,
+    /// Whether the window was full and the rate limit didn't hold the
+    /// request back, so a larger window would have sent more
+    window_bound: bool,
+}
+
+impl Controller {
+    pub fn new(gap: Duration) -> Self {
+        Controller {
+            gap,
+            state: Mutex::new(State {
+                window: 1.0,
+                in_flight: 0,
+                next_turn: Instant::now(),
+                usual: None,
+                cut_at: None,
+            }),
+            freed: Condvar::new(),
+        }
+    }
+
+    pub fn gap(&self) -> Duration {
+        self.gap
+    }
+
+    /// Requests allowed in flight now
+    pub fn window(&self) -> usize {
+        self.state.lock().unwrap().window as usize
+    }
+
+    /// Roughly when a request would be sent if it asked now
+    pub fn next_free(&self) -> Instant {
+        let state = self.state.lock().unwrap();
+        let turn = state.next_turn.max(Instant::now());
+        if state.in_flight < state.window as usize {
+            turn
+        } else {
+            turn + state.usual.unwrap_or(self.gap)
+        }
+    }
+
+    /// Wait for room in the window and for the request's turn, at least the
+    /// gap after the one before
+    pub fn acquire(&self) -> Ticket {
+        let asked = Instant::now();
+        let mut state = self.state.lock().unwrap();
+        while state.in_flight >= state.window as usize {
+            state = self.freed.wait(state).unwrap();
+        }
+        state.in_flight += 1;
+
+        let now = Instant::now();
+        let turn = state.next_turn.max(now);
+        state.next_turn = turn + self.gap;
+        let window_bound = state.in_flight >= state.window as usize && turn == now;
+        drop(state);
+
+        thread::sleep(turn - now);
+        Ticket {
+            wait: asked.elapsed(),
+            sent: Instant::now(),
+            window_bound,
+        }
+    }
+
+    /// Give back the place of a request whose response came back or failed,
+    /// `ok` unless it was an error the server may be overloaded by
+    pub fn release(&self, ticket: Ticket, ok: bool) {
+        let latency = ticket.sent.elapsed();
+        let mut state = self.state.lock().unwrap();
+        state.in_flight -= 1;
+
+        let usual = state.usual.unwrap_or(latency);
+        let slow = latency.as_secs_f64() > usual.as_secs_f64() * SLOW;
+        state.usual = Some(usual.mul_f64(1.0 - SMOOTHING) + latency.mul_f64(SMOOTHING));
+
+        if !ok || slow {
+            // A burst of bad responses to requests sent together is one
+            if state.cut_at.is_none_or(|at| at.elapsed() >= usual) {
+                state.window = (state.window / 2.0).max(1.0);
+                state.cut_at = Some(Instant::now());
+            }
+        } else if ticket.window_bound {
+            state.window = (state.window + 1.0 / state.window).min(MAX_IN_FLIGHT as f64);
+        }
+
+        drop(state);
+        self.freed.notify_all();
+    }
+}
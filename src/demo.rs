@@ -0,0 +1,270 @@
+use std::{
+    hash::{BuildHasher, RandomState},
+    io::{self, BufRead, BufReader, Write},
+    net::{Ipv4Addr, TcpListener, TcpStream},
+    thread,
+};
+
+use reqwest as rw;
+use serde_json::{json, Value};
+
+use crate::title;
+
+/// Pair searched when no start and end are given
+pub const START: &str = "Cat";
+pub const END: &str = "Philosophy";
+
+/// Title, categories and the articles linked to, in page order
+type Article = (
+    &'static str,
+    &'static [&'static str],
+    &'static [&'static str],
+);
+
+const ARTICLES: &[Article] = &[
+    ("Cat", &["Mammals", "Pets"], &["Mammal", "Dogs", "Ferret"]),
+    ("Dog", &["Mammals", "Pets"], &["Mammal", "Wolf", "Cat"]),
+    ("Wolf", &["Mammals"], &["Mammal", "Forest"]),
+    ("Mammal", &["Animals"], &["Animal", "Vertebrate"]),
+    ("Vertebrate", &["Animals"], &["Animal", "Fish"]),
+    ("Fish", &["Animals"], &["Water", "Animal"]),
+    ("Animal", &["Animals", "Biology"], &["Biology", "Cell"]),
+    ("Cell", &["Biology"], &["Biology", "DNA"]),
+    ("DNA", &["Biology", "Chemistry"], &["Chemistry"]),
+    ("Forest", &["Ecology"], &["Tree"]),
+    ("Tree", &["Ecology"], &["Forest", "Biology"]),
+    ("Water", &["Chemistry"], &["Chemistry"]),
+    (
+        "Biology",
+        &["Biology", "Academic_disciplines"],
+        &["Science", "Cell"],
+    ),
+    (
+        "Chemistry",
+        &["Chemistry", "Academic_disciplines"],
+        &["Science", "Water"],
+    ),
+    ("Science", &["Academic_disciplines"], &["Philosophy"]),
+    (
+        "Philosophy",
+        &["Philosophy", "Academic_disciplines"],
+        &["Science"],
+    ),
+];
+
+/// Redirects of the demo wiki: from and to
+const REDIRECTS: &[(&str, &str)] = &[("Dogs", "Dog"), ("Mammals", "Mammal")];
+
+/// A small synthetic wiki served over HTTP from a background thread, with
+/// the page and API URLs of a real one. Its articles link to each other in
+/// the markup MediaWiki produces, side links and all, so searches run
+/// through the same fetching and parsing as against the live wiki.
+pub struct Server {
+    base: String,
+}
+
+impl Server {
+    /// Listen on a free port of the loopback interface
+    pub fn start() -> io::Result<Self> {
+        let listener = TcpListener::bind((Ipv4Addr::LOCALHOST, 0))?;
+        let base = format!("http://{}", listener.local_addr()?);
+
+        thread::spawn(move || {
+            for stream in listener.incoming().flatten() {
+                thread::spawn(move || {
+                    if let Err(err) = serve(stream) {
+                        eprintln!("Demo wiki: {}", err);
+                    }
+                });
+            }
+        });
+
+        Ok(Server { base })
+    }
+
+    /// URL to send requests to, e.g. `http://127.0.0.1:8080`
+    pub fn base(&self) -> &str {
+        &self.base
+    }
+}
+
+/// Answer the one request of a connection
+fn serve(mut stream: TcpStream) -> io::Result<()> {
+    let mut reader = BufReader::new(stream.try_clone()?);
+    let mut request_line = String::new();
+    reader.read_line(&mut request_line)?;
+    // Headers are of no use
+    let mut line = String::new();
+    while reader.read_line(&mut line)? > 2 {
+        line.clear();
+    }
+
+    let target = request_line.split_whitespace().nth(1).unwrap_or("/");
+    let (status, content_type, body) = match rw::Url::parse(&format!("http://demo{}", target)) {
+        Ok(url) if url.path() == "/w/api.php" => ("200 OK", "application/json", api(&url)),
+        Ok(url) => match url.path().strip_prefix("/wiki/") {
+            Some("Special:Random") => {
+                let (title, _, _) =
+                    ARTICLES[RandomState::new().hash_one(0) as usize % ARTICLES.len()];
+                let header = format!(
+                    "HTTP/1.1 302 Found\r\nLocation: /wiki/{}\r\nContent-Length: 0\r\nConnection: close\r\n\r\n",
+                    title
+                );
+                return stream.write_all(header.as_bytes());
+            }
+            Some(name) => match page(&title::decode(name)) {
+                Some(html) => ("200 OK", "text/html; charset=utf-8", html),
+                None => (
+                    "404 Not Found",
+                    "text/html; charset=utf-8",
+                    missing_page(name),
+                ),
+            },
+            None => ("404 Not Found", "text/plain", "Not found\n".to_string()),
+        },
+        Err(_) => ("400 Bad Request", "text/plain", "Bad request\n".to_string()),
+    };
+
+    let header = format!(
+        "HTTP/1.1 {}\r\nContent-Type: {}\r\nContent-Length: {}\r\nConnection: close\r\n\r\n",
+        status,
+        content_type,
+        body.len()
+    );
+    stream.write_all(header.as_bytes())?;
+    stream.write_all(body.as_bytes())
+}
+
+/// The article `name` redirects to, or `name` itself
+fn resolve(name: &str) -> &str {
+    REDIRECTS
+        .iter()
+        .find(|(from, _)| *from == name)
+        .map_or(name, |(_, to)| to)
+}
+
+/// The article `name` names, following redirects
+fn article(name: &str) -> Option<&'static Article> {
+    let name = resolve(name);
+    ARTICLES.iter().find(|(title, _, _)| *title == name)
+}
+
+/// HTML of the article `name`, following redirects
+fn page(name: &str) -> Option<String> {
+    let (title, _, links) = article(name)?;
+
+    let paragraphs: String = links
+        .iter()
+        .map(|link| {
+            format!(
+                r#"<p>{title} is related to <a href="/wiki/{link}" title="{link}">{link}</a>.</p>"#
+            )
+        })
+        .collect();
+
+    // Navigation, a red link, a link to the article itself and the
+    // references, none of which should be followed
+    Some(format!(
+        r#"<!DOCTYPE html>
+<html><head><title>{title} - Demo wiki</title></head>
+<body>
+<div id="mw-navigation"><a href="/wiki/Main_Page">Main page</a> <a href="/wiki/Special:Random">Random article</a></div>
+<div id="mw-content-text" class="mw-body-content"><div class="mw-parser-output">
+<p><b>{title}</b> is an article of the demo wiki. See also <a href="/wiki/{title}#History">its history</a> and <a href="/w/index.php?title=Unicorn&amp;action=edit&amp;redlink=1" class="new">Unicorn</a>.</p>
+{paragraphs}
+<h2 id="References">References</h2>
+<ol class="references"><li><a href="/wiki/Main_Page">Main page</a> <a href="https://example.org/">Source</a></li></ol>
+</div></div>
+</body></html>
+"#
+    ))
+}
+
+fn missing_page(name: &str) -> String {
+    format!(
+        "<!DOCTYPE html>\n<html><body><div id=\"mw-content-text\"><p>The demo wiki has no article named {}.</p></div></body></html>\n",
+        title::decode(name)
+    )
+}
+
+/// Answer the Action API queries searches make: `action=query` for
+/// `titles`, with redirects, categories and the redirects to each page.
+/// Other queries get an API error.
+fn api(url: &rw::Url) -> String {
+    let param = |key: &str| {
+        url.query_pairs()
+            .find(|(k, _)| k == key)
+            .map(|(_, v)| v.into_owned())
+    };
+    let props = param("prop").unwrap_or_default();
+
+    if let (Some("query"), Some("backlinks"), Some(target)) = (
+        param("action").as_deref(),
+        param("list").as_deref(),
+        param("bltitle"),
+    ) {
+        // Links through redirects go to the redirect, as on a real wiki
+        let target = title::underscored(&target);
+        let backlinks: Vec<Value> = ARTICLES
+            .iter()
+            .filter(|(_, _, links)| links.contains(&target.as_str()))
+            .map(|(title, _, _)| json!({ "ns": 0, "title": title.replace('_', " ") }))
+            .collect();
+        return json!({ "batchcomplete": true, "query": { "backlinks": backlinks } }).to_string();
+    }
+    if let (Some("query"), Some("categorymembers"), Some(category)) = (
+        param("action").as_deref(),
+        param("list").as_deref(),
+        param("cmtitle"),
+    ) {
+        let name = title::underscored(category.trim_start_matches("Category:"));
+        let members: Vec<Value> = ARTICLES
+            .iter()
+            .filter(|(_, categories, _)| categories.contains(&name.as_str()))
+            .map(|(title, _, _)| json!({ "ns": 0, "title": title.replace('_', " ") }))
+            .collect();
+        return json!({ "batchcomplete": true, "query": { "categorymembers": members } })
+            .to_string();
+    }
+
+    let (Some("query"), Some(titles)) = (param("action").as_deref(), param("titles")) else {
+        let error = json!({ "error": { "info": "the demo wiki doesn't support this query" } });
+        return error.to_string();
+    };
+
+    let mut redirects = Vec::new();
+    let mut pages = Vec::new();
+    for t in titles.split('|') {
+        let name = title::underscored(t);
+        let resolved = resolve(&name);
+        if resolved != name {
+            redirects.push(json!({ "from": t, "to": resolved.replace('_', " ") }));
+        }
+
+        let mut page = json!({ "ns": 0, "title": resolved.replace('_', " ") });
+        match article(resolved) {
+            Some((title, categories, _)) => {
+                if props.contains("categories") {
+                    let categories: Vec<Value> = categories
+                        .iter()
+                        .map(|c| json!({ "ns": 14, "title": format!("Category:{}", c.replace('_', " ")) }))
+                        .collect();
+                    page["categories"] = categories.into();
+                }
+                if props.contains("redirects") {
+                    let from: Vec<Value> = REDIRECTS
+                        .iter()
+                        .filter(|(_, to)| to == title)
+                        .map(|(from, _)| json!({ "ns": 0, "title": from.replace('_', " ") }))
+                        .collect();
+                    page["redirects"] = from.into();
+                }
+            }
+            None => page["missing"] = true.into(),
+        }
+        pages.push(page);
+    }
+
+    json!({ "batchcomplete": true, "query": { "redirects": redirects, "pages": pages } })
+        .to_string()
+}
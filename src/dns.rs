@@ -0,0 +1,257 @@
+use std::{
+    net::{IpAddr, Ipv4Addr, Ipv6Addr, SocketAddr, ToSocketAddrs, UdpSocket},
+    time::{Duration, SystemTime, UNIX_EPOCH},
+};
+
+use reqwest::dns::{Addrs, Name, Resolve, Resolving};
+use tokio::task;
+
+use crate::wiki::Error;
+
+const QUERY_TIMEOUT: Duration = Duration::from_secs(5);
+
+/// Record types
+const A: u16 = 1;
+const AAAA: u16 = 28;
+
+/// Address family to try first
+#[derive(Clone, Copy, Debug, PartialEq, Eq, clap::ValueEnum)]
+pub enum IpFamily {
+    V4,
+    V6,
+}
+
+/// Resolves host names with the system resolver or by asking `server`
+/// directly, listing the addresses of the preferred family first so
+/// connections fall back to the other only if those fail.
+pub struct Resolver {
+    pub server: Option<SocketAddr>,
+    pub prefer: Option<IpFamily>,
+}
+
+impl Resolve for Resolver {
+    fn resolve(&self, name: Name) -> Resolving {
+        let (server, prefer) = (self.server, self.prefer);
+        let host = name.as_str().to_string();
+
+        // Lookups block, so they run on threads of their own rather than
+        // stall the other requests in flight
+        Box::pin(async move {
+            let mut addrs: Vec<IpAddr> = match server {
+                Some(server) => {
+                    let ask = |rtype| {
+                        let host = host.clone();
+                        task::spawn_blocking(move || query(server, &host, rtype))
+                    };
+                    let (v4, v6) = (ask(A), ask(AAAA));
+                    match (v4.await?, v6.await?) {
+                        (Err(err), Err(_)) => return Err(err),
+                        // A host may have addresses of one family only, and a
+                        // server may fail to answer for the other
+                        (v4, v6) => v4.into_iter().chain(v6).flatten().collect(),
+                    }
+                }
+                None => {
+                    let host = host.clone();
+                    task::spawn_blocking(move || (host.as_str(), 0).to_socket_addrs())
+                        .await??
+                        .map(|addr| addr.ip())
+                        .collect()
+                }
+            };
+            if addrs.is_empty() {
+                return Err(format!("{} has no address", host).into());
+            }
+
+            // Stable, so the resolver's order is kept within each family
+            if let Some(prefer) = prefer {
+                addrs.sort_by_key(|ip| (ip.is_ipv4() != (prefer == IpFamily::V4)) as u8);
+            }
+
+            let addrs: Addrs = Box::new(addrs.into_iter().map(|ip| SocketAddr::new(ip, 0)));
+            Ok(addrs)
+        })
+    }
+}
+
+/// Ask `server` for the records of type `rtype` of `host`
+fn query(server: SocketAddr, host: &str, rtype: u16) -> Result<Vec<IpAddr>, Error> {
+    let id = SystemTime::now()
+        .duration_since(UNIX_EPOCH)
+        .unwrap_or_default()
+        .subsec_nanos() as u16;
+
+    // Header: ID, recursion desired, one question
+    let mut packet = Vec::with_capacity(512);
+    packet.extend_from_slice(&id.to_be_bytes());
+    packet.extend_from_slice(&[0x01, 0x00, 0, 1, 0, 0, 0, 0, 0, 0]);
+    for label in host.trim_end_matches('.').split('.') {
+        if label.is_empty() || label.len() > 63 {
+            return Err(format!("invalid host name {}", host).into());
+        }
+        packet.push(label.len() as u8);
+        packet.extend_from_slice(label.as_bytes());
+    }
+    packet.push(0);
+    packet.extend_from_slice(&rtype.to_be_bytes());
+    packet.extend_from_slice(&1u16.to_be_bytes());
+
+    let local: SocketAddr = if server.is_ipv4() {
+        (Ipv4Addr::UNSPECIFIED, 0).into()
+    } else {
+        (Ipv6Addr::UNSPECIFIED, 0).into()
+    };
+    let socket = UdpSocket::bind(local)?;
+    socket.set_read_timeout(Some(QUERY_TIMEOUT))?;
+    socket.send_to(&packet, server)?;
+
+    let mut buf = [0; 4096];
+    let len = loop {
+        let (len, from) = socket.recv_from(&mut buf)?;
+        if from == server && len >= 12 && buf[..2] == id.to_be_bytes() {
+            break len;
+        }
+    };
+
+    parse_answers(&buf[..len], rtype)
+        .ok_or_else(|| format!("malformed DNS response from {} for {}", server, host).into())
+        .and_then(|res| res)
+}
+
+/// Addresses in a DNS response, `None` if it is malformed
+fn parse_answers(msg: &[u8], rtype: u16) -> Option<Result<Vec<IpAddr>, Error>> {
+    let u16_at = |at: usize| Some(u16::from_be_bytes([*msg.get(at)?, *msg.get(at + 1)?]));
+
+    match msg.get(3)? & 0x0f {
+        0 => {}
+        // Name error: the host doesn't exist
+        3 => return Some(Ok(Vec::new())),
+        rcode => return Some(Err(format!("DNS error {}", rcode).into())),
+    }
+    let (questions, answers) = (u16_at(4)?, u16_at(6)?);
+
+    let mut at = 12;
+    for _ in 0..questions {
+        at = skip_name(msg, at)? + 4;
+    }
+
+    let mut addrs = Vec::new();
+    for _ in 0..answers {
+        at = skip_name(msg, at)?;
+        let (kind, len) = (u16_at(at)?, u16_at(at + 8)? as usize);
+        let data = msg.get(at + 10..at + 10 + len)?;
+        at += 10 + len;
+
+        // CNAMEs come first, followed by the records of their target
+        match (kind == rtype, data.len()) {
+            (true, 4) => addrs.push(IpAddr::from(<[u8; 4]>::try_from(data).ok()?)),
+            (true, 16) => addrs.push(IpAddr::from(<[u8; 16]>::try_from(data).ok()?)),
+            _ => {}
+        }
+    }
+
+    Some(Ok(addrs))
+}
+
+/// Offset just past the possibly compressed name at `at`
+fn skip_name(msg: &[u8], mut at: usize) -> Option<usize> {
+    loop {
+        let len = *msg.get(at)?;
+        match len {
+            0 => return Some(at + 1),
+            // Pointer to an earlier name, ending this one
+            l if l & 0xc0 == 0xc0 => return Some(at + 2),
+            l => at += 1 + l as usize,
+        }
+    }
+}
+
+/// Parse a DNS server address, the port defaulting to 53
+pub fn parse_server(arg: &str) -> Result<SocketAddr, String> {
+    arg.parse::<SocketAddr>()
+        .or_else(|_| arg.parse::<IpAddr>().map(|ip| SocketAddr::new(ip, 53)))
+        .map_err(|_| format!("{} is not an IP address", arg))
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    /// Response header with `rcode`, questions then answers, and the
+    /// question for en.wikipedia.org
+    fn response(rcode: u8, answers: u16) -> Vec<u8> {
+        let mut msg = vec![0x12, 0x34, 0x81, 0x80 | rcode, 0, 1];
+        msg.extend_from_slice(&answers.to_be_bytes());
+        msg.extend_from_slice(&[0, 0, 0, 0]);
+        msg.extend_from_slice(b"\x02en\x09wikipedia\x03org\x00");
+        msg.extend_from_slice(&[0, 1, 0, 1]);
+        msg
+    }
+
+    /// Answer of type `kind` for the name at `name`, a pointer
+    fn answer(msg: &mut Vec<u8>, name: u8, kind: u16, data: &[u8]) {
+        msg.extend_from_slice(&[0xc0, name]);
+        msg.extend_from_slice(&kind.to_be_bytes());
+        msg.extend_from_slice(&[0, 1, 0, 0, 0x0e, 0x10]);
+        msg.extend_from_slice(&(data.len() as u16).to_be_bytes());
+        msg.extend_from_slice(data);
+    }
+
+    fn addrs(msg: &[u8], rtype: u16) -> Vec<IpAddr> {
+        parse_answers(msg, rtype).unwrap().unwrap()
+    }
+
+    #[test]
+    fn follows_compressed_names_through_cnames() {
+        let mut msg = response(0, 2);
+        // en.wikipedia.org is dyna.wikimedia.org, whose "org" points back
+        // into the question
+        let mut cname = b"\x04dyna\x09wikimedia".to_vec();
+        cname.extend_from_slice(&[0xc0, 25]);
+        answer(&mut msg, 12, 5, &cname);
+        let target = msg.len() as u8 - cname.len() as u8;
+        answer(&mut msg, target, A, &[185, 15, 59, 224]);
+
+        assert_eq!(addrs(&msg, A), [IpAddr::from([185, 15, 59, 224])]);
+    }
+
+    #[test]
+    fn reads_ipv6_addresses() {
+        let mut msg = response(0, 1);
+        let ip = Ipv6Addr::new(0x2a02, 0xec80, 0x300, 0xed1a, 0, 0, 0, 1);
+        answer(&mut msg, 12, AAAA, &ip.octets());
+
+        assert_eq!(addrs(&msg, AAAA), [IpAddr::from(ip)]);
+    }
+
+    #[test]
+    fn skips_other_record_types() {
+        let mut msg = response(0, 3);
+        answer(&mut msg, 12, AAAA, &[0; 16]);
+        // TXT, as long as an IPv4 address
+        answer(&mut msg, 12, 16, b"\x03abc");
+        answer(&mut msg, 12, A, &[10, 0, 0, 1]);
+
+        assert_eq!(addrs(&msg, A), [IpAddr::from([10, 0, 0, 1])]);
+    }
+
+    #[test]
+    fn missing_host_has_no_addresses() {
+        assert!(addrs(&response(3, 0), A).is_empty());
+    }
+
+    #[test]
+    fn server_errors_are_errors() {
+        assert!(parse_answers(&response(2, 0), A).unwrap().is_err());
+    }
+
+    #[test]
+    fn truncated_responses_are_malformed() {
+        let mut msg = response(0, 1);
+        answer(&mut msg, 12, A, &[10, 0, 0, 1]);
+
+        for len in [0, 3, 7, 20, msg.len() - 9, msg.len() - 1] {
+            assert!(parse_answers(&msg[..len], A).is_none(), "{} bytes", len);
+        }
+    }
+}
@@ -0,0 +1,91 @@
+use std::{
+    collections::HashMap,
+    fs::File,
+    io::{self, BufRead, BufReader},
+    path::Path,
+};
+
+use crate::title;
+
+/// Word vectors loaded from a GloVe or word2vec text file: one word per line
+/// followed by its components, word2vec files starting with a
+/// `<words> <dimensions>` header line.
+pub struct Embeddings {
+    vectors: HashMap<String, Vec<f32>>,
+}
+
+impl Embeddings {
+    pub fn load(path: &Path) -> io::Result<Self> {
+        let mut vectors = HashMap::new();
+        let mut dim = None;
+
+        for (n, line) in BufReader::new(File::open(path)?).lines().enumerate() {
+            let line = line?;
+            let mut fields = line.split_whitespace();
+            let Some(word) = fields.next() else {
+                continue;
+            };
+            let vector: Vec<f32> = fields.filter_map(|f| f.parse().ok()).collect();
+
+            if n == 0 && vector.len() == 1 && word.parse::<u64>().is_ok() {
+                continue;
+            }
+            if *dim.get_or_insert(vector.len()) != vector.len() {
+                return Err(io::Error::new(
+                    io::ErrorKind::InvalidData,
+                    format!("line {}: expected {} components", n + 1, dim.unwrap_or(0)),
+                ));
+            }
+            vectors.insert(word.to_string(), vector);
+        }
+
+        Ok(Embeddings { vectors })
+    }
+
+    /// Mean vector of the words of `name`, a title in URL form, or `None` if
+    /// none of them is known
+    pub fn title_vector(&self, name: &str) -> Option<Vec<f32>> {
+        let decoded = title::decode(name);
+        let mut sum: Option<Vec<f32>> = None;
+        let mut words = 0;
+
+        for word in decoded
+            .split(|c: char| !c.is_alphanumeric())
+            .filter(|w| !w.is_empty())
+        {
+            let vector = self
+                .vectors
+                .get(word)
+                .or_else(|| self.vectors.get(&word.to_lowercase()));
+            if let Some(vector) = vector {
+                let sum = sum.get_or_insert_with(|| vec![0.0; vector.len()]);
+                for (s, v) in sum.iter_mut().zip(vector) {
+                    *s += v;
+                }
+                words += 1;
+            }
+        }
+
+        sum.map(|mut sum| {
+            for s in &mut sum {
+                *s /= words as f32;
+            }
+            sum
+        })
+    }
+}
+
+/// Cosine similarity of `a` and `b`, 0 if either is zero
+pub fn cosine(a: &[f32], b: &[f32]) -> f64 {
+    let (mut dot, mut norm_a, mut norm_b) = (0.0f64, 0.0f64, 0.0f64);
+    for (&x, &y) in a.iter().zip(b) {
+        dot += x as f64 * y as f64;
+        norm_a += x as f64 * x as f64;
+        norm_b += y as f64 * y as f64;
+    }
+
+    if norm_a == 0.0 || norm_b == 0.0 {
+        return 0.0;
+    }
+    dot / (norm_a.sqrt() * norm_b.sqrt())
+}
@@ -0,0 +1,143 @@
+use std::collections::HashSet;
+
+use crate::{
+    title,
+    wiki::{Error, Wiki},
+};
+
+/// Number of the start article's links whose own links are sampled
+const SAMPLE_SIZE: usize = 5;
+
+/// Stop counting backlinks after this many pages of API results
+const MAX_BACKLINK_PAGES: usize = 10;
+
+/// Predict how many requests and how long a search from `start` to `end`
+/// will take, from a handful of requests around both ends.
+///
+/// The model assumes every expanded article is as likely as any other to
+/// link to `end`, so about `articles / backlinks` articles are expanded
+/// before one does.
+pub fn run(wiki: &Wiki, start: &str, end: &str, max_depth: u32) {
+    if let Err(err) = estimate(wiki, start, end, max_depth) {
+        eprintln!("{}", err);
+    }
+}
+
+fn estimate(wiki: &Wiki, start: &str, end: &str, max_depth: u32) -> Result<(), Error> {
+    let start_links = distinct(wiki.links(start)?);
+    if start_links.iter().any(|l| l == end) {
+        println!("{} links directly to {}", start, end);
+        println!("Expected requests: 1");
+        return Ok(());
+    }
+
+    // Spread the sample over the page instead of taking the lead's links
+    let step = (start_links.len() / SAMPLE_SIZE).max(1);
+    let mut sampled = Vec::new();
+    for link in start_links.iter().step_by(step).take(SAMPLE_SIZE) {
+        match wiki.links(link) {
+            Ok(links) => sampled.push(distinct(links).len()),
+            Err(err) => eprintln!("{}", err),
+        }
+    }
+    let branching = if sampled.is_empty() {
+        start_links.len() as f64
+    } else {
+        sampled.iter().sum::<usize>() as f64 / sampled.len() as f64
+    };
+
+    let (backlinks, capped) = backlinks(wiki, end)?;
+    let articles = article_count(wiki)?;
+
+    println!("Links from {}: {}", start, start_links.len());
+    println!("Branching factor: ~{:.0}", branching);
+    println!(
+        "Backlinks to {}: {}{}",
+        end,
+        backlinks,
+        if capped { "+" } else { "" }
+    );
+    if backlinks == 0 {
+        println!("Nothing links to {}, no path can be found", end);
+        return Ok(());
+    }
+
+    let requests = (articles as f64 / backlinks as f64).max(1.0);
+
+    // Depth of the level in which the expected request falls
+    let mut depth = 0;
+    let mut level = 1.0;
+    let mut expanded = 1.0;
+    while expanded < requests {
+        level *= if depth == 0 {
+            start_links.len() as f64
+        } else {
+            branching
+        };
+        expanded += level;
+        depth += 1;
+    }
+
+    println!("Expected path length: {}", depth + 2);
+    if depth > max_depth {
+        println!("This is deeper than the maximum depth of {}", max_depth);
+    }
+    println!("Expected requests: ~{:.0}", requests);
+
+    let secs = requests * wiki.req_wait().as_secs_f64();
+    let time = jiff::SignedDuration::from_secs_f64(secs.round());
+    println!("Expected time: ~{time:#}");
+
+    Ok(())
+}
+
+fn distinct(links: Vec<String>) -> Vec<String> {
+    let mut seen = HashSet::new();
+    links
+        .into_iter()
+        .filter(|l| seen.insert(l.clone()))
+        .collect()
+}
+
+/// Count the articles linking to `article`, in URL form, and whether
+/// counting stopped early
+fn backlinks(wiki: &Wiki, article: &str) -> Result<(usize, bool), Error> {
+    let title = title::decode(article);
+    let mut count = 0;
+    let mut cont = String::new();
+
+    for _ in 0..MAX_BACKLINK_PAGES {
+        let mut params = vec![
+            ("action", "query"),
+            ("list", "backlinks"),
+            ("bltitle", title.as_str()),
+            ("blnamespace", "0"),
+            ("bllimit", "max"),
+        ];
+        if !cont.is_empty() {
+            params.push(("blcontinue", &cont));
+        }
+
+        let res = wiki.api(&params)?;
+        count += res["query"]["backlinks"].as_array().map_or(0, |b| b.len());
+
+        match res["continue"]["blcontinue"].as_str() {
+            Some(next) => cont = next.to_string(),
+            None => return Ok((count, false)),
+        }
+    }
+
+    Ok((count, true))
+}
+
+fn article_count(wiki: &Wiki) -> Result<u64, Error> {
+    let res = wiki.api(&[
+        ("action", "query"),
+        ("meta", "siteinfo"),
+        ("siprop", "statistics"),
+    ])?;
+
+    res["query"]["statistics"]["articles"]
+        .as_u64()
+        .ok_or_else(|| "missing article count in siteinfo".into())
+}
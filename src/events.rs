@@ -0,0 +1,56 @@
+use std::{
+    fs::File,
+    io::{self, Write},
+    path::Path,
+    sync::Mutex,
+    time::Instant,
+};
+
+use serde_json::{json, Value};
+
+/// JSON Lines sink for search events.
+///
+/// Each line is flushed as soon as it is written so other programs can tail
+/// the output while the search is running. Events can be emitted from
+/// several threads at once.
+pub struct Events {
+    out: Mutex<Option<Box<dyn Write + Send>>>,
+    start: Instant,
+}
+
+impl Events {
+    /// Open the event stream, `-` meaning stdout. `None` disables events.
+    pub fn open(dest: Option<&Path>) -> io::Result<Self> {
+        let out: Option<Box<dyn Write + Send>> = match dest {
+            None => None,
+            Some(path) if path == Path::new("-") => Some(Box::new(io::stdout())),
+            Some(path) => Some(Box::new(File::create(path)?)),
+        };
+
+        Ok(Events {
+            out: Mutex::new(out),
+            start: Instant::now(),
+        })
+    }
+
+    /// Write one event of kind `event`, merging the fields of `data` into it.
+    pub fn emit(&self, event: &str, data: Value) {
+        let mut out = self.out.lock().unwrap();
+        let Some(w) = out.as_mut() else {
+            return;
+        };
+
+        let mut line = json!({
+            "event": event,
+            "elapsed_ms": self.start.elapsed().as_millis() as u64,
+        });
+        if let (Some(line), Value::Object(data)) = (line.as_object_mut(), data) {
+            line.extend(data);
+        }
+
+        if let Err(err) = writeln!(w, "{}", line).and_then(|_| w.flush()) {
+            eprintln!("{}", err);
+            *out = None;
+        }
+    }
+}
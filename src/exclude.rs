@@ -0,0 +1,69 @@
+use std::{
+    collections::{HashMap, HashSet},
+    sync::Mutex,
+};
+
+use crate::{
+    title,
+    wiki::{Error, Wiki},
+};
+
+/// Categories whose articles searches don't go through, e.g. stubs or
+/// disambiguation pages.
+///
+/// Whether an article is in one of them is only asked once it is linked to,
+/// for all the new links of an article at once, and remembered.
+pub struct Exclude {
+    /// With their prefix, in URL form
+    categories: Vec<String>,
+    /// Names of the categories as the API gives them, without the prefix
+    names: HashSet<String>,
+    /// Whether each article asked about is in one
+    cache: Mutex<HashMap<String, bool>>,
+}
+
+impl Exclude {
+    pub fn new(categories: Vec<String>) -> Self {
+        let names = categories
+            .iter()
+            .map(|c| {
+                let name = c.strip_prefix("Category:").unwrap_or(c);
+                title::decode(name).replace('_', " ")
+            })
+            .collect();
+
+        Exclude {
+            categories,
+            names,
+            cache: Mutex::new(HashMap::new()),
+        }
+    }
+
+    /// Those of `names` in an excluded category
+    pub fn excluded(&self, wiki: &Wiki, names: &[&str]) -> Result<HashSet<String>, Error> {
+        let missing: Vec<&str> = {
+            let cache = self.cache.lock().unwrap();
+            names
+                .iter()
+                .filter(|name| !cache.contains_key(**name))
+                .copied()
+                .collect()
+        };
+        if !missing.is_empty() {
+            let categories = wiki.categories_among(&missing, &self.categories)?;
+            let mut cache = self.cache.lock().unwrap();
+            for (name, cats) in categories {
+                // The demo wiki gives every category
+                let excluded = cats.iter().any(|c| self.names.contains(c));
+                cache.insert(name, excluded);
+            }
+        }
+
+        let cache = self.cache.lock().unwrap();
+        Ok(names
+            .iter()
+            .filter(|name| cache.get(**name).copied().unwrap_or(false))
+            .map(|name| name.to_string())
+            .collect())
+    }
+}
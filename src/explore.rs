@@ -0,0 +1,75 @@
+use crate::{events::Events, search, title, wiki::Wiki};
+
+/// Number of deepest articles listed
+const MAX_LISTED: usize = 10;
+
+/// Search outward from `start` with no end, fetching at most `budget`
+/// articles, and report how many articles each depth holds and which are the
+/// farthest reached. When the frontier runs out within the budget, the
+/// deepest level is the eccentricity of `start`.
+pub fn run(
+    wiki: &Wiki,
+    events: &Events,
+    opts: &search::Options,
+    start: &title::Arg,
+    budget: usize,
+) {
+    let start = match start.resolve(wiki) {
+        Ok(start) => start,
+        Err(err) => {
+            eprintln!("{}", err);
+            return;
+        }
+    };
+
+    let opts = search::Options {
+        all: false,
+        all_shortest: false,
+        budget: Some(budget),
+        ..*opts
+    };
+    let explored = search::bfs(wiki, events, &opts, &start, None, |_| {});
+
+    let stats = explored.stats();
+    let max_depth = stats.max_depth;
+    let mut histogram = vec![0; max_depth as usize + 1];
+    for &depth in &explored.depth[1..] {
+        histogram[depth as usize] += 1;
+    }
+
+    println!(
+        "Fetched {} articles, discovered {}",
+        stats.expanded, stats.discovered
+    );
+    println!("Depth  Articles");
+    for (depth, count) in histogram.iter().enumerate() {
+        println!("{:>5}  {}", depth, count);
+    }
+
+    let deepest: Vec<&str> = (1..explored.articles.len())
+        .filter(|&idx| explored.depth[idx] == max_depth)
+        .map(|idx| explored.articles[idx].as_str())
+        .collect();
+    print!(
+        "Deepest (depth {}): {}",
+        max_depth,
+        deepest[..deepest.len().min(MAX_LISTED)].join(", ")
+    );
+    if deepest.len() > MAX_LISTED {
+        print!(" and {} more", deepest.len() - MAX_LISTED);
+    }
+    println!();
+
+    // Every article discovered is fetched, or failed to be, once the
+    // frontier runs out
+    let fetched: usize = explored.levels.iter().map(|level| level.fetched).sum();
+    if fetched == stats.discovered {
+        println!("Eccentricity of {}: {}", start, max_depth);
+    } else if fetched >= budget {
+        println!("Budget used up, articles may lie deeper");
+    } else if max_depth > opts.max_depth {
+        println!("Stopped at the maximum depth, articles may lie deeper");
+    } else {
+        println!("Stopped early, articles may lie deeper");
+    }
+}
@@ -0,0 +1,126 @@
+use std::{
+    fs::File,
+    io::{self, BufWriter, Write},
+    path::Path,
+};
+
+/// Write the explored articles and links to `path`.
+///
+/// `articles[0]` is the root placeholder and is skipped. Node ids are the
+/// discovery order, which is also exported as an attribute next to the depth.
+pub fn export(
+    path: &Path,
+    articles: &[String],
+    depths: &[u32],
+    edges: &[(usize, usize)],
+) -> io::Result<()> {
+    let mut w = BufWriter::new(File::create(path)?);
+
+    if path.extension().is_some_and(|ext| ext == "gexf") {
+        write_gexf(&mut w, articles, depths, edges)?;
+    } else {
+        write_graphml(&mut w, articles, depths, edges)?;
+    }
+
+    w.flush()
+}
+
+fn write_graphml(
+    w: &mut impl Write,
+    articles: &[String],
+    depths: &[u32],
+    edges: &[(usize, usize)],
+) -> io::Result<()> {
+    writeln!(w, r#"<?xml version="1.0" encoding="UTF-8"?>"#)?;
+    writeln!(
+        w,
+        r#"<graphml xmlns="http://graphml.graphdrawing.org/xmlns">"#
+    )?;
+    writeln!(
+        w,
+        r#"  <key id="title" for="node" attr.name="title" attr.type="string"/>"#
+    )?;
+    writeln!(
+        w,
+        r#"  <key id="depth" for="node" attr.name="depth" attr.type="int"/>"#
+    )?;
+    writeln!(
+        w,
+        r#"  <key id="order" for="node" attr.name="order" attr.type="int"/>"#
+    )?;
+    writeln!(w, r#"  <graph id="G" edgedefault="directed">"#)?;
+
+    for (idx, article) in articles.iter().enumerate().skip(1) {
+        writeln!(
+            w,
+            r#"    <node id="n{idx}"><data key="title">{}</data><data key="depth">{}</data><data key="order">{idx}</data></node>"#,
+            escape(article),
+            depths[idx],
+        )?;
+    }
+    for (source, target) in edges {
+        writeln!(w, r#"    <edge source="n{source}" target="n{target}"/>"#)?;
+    }
+
+    writeln!(w, "  </graph>")?;
+    writeln!(w, "</graphml>")
+}
+
+fn write_gexf(
+    w: &mut impl Write,
+    articles: &[String],
+    depths: &[u32],
+    edges: &[(usize, usize)],
+) -> io::Result<()> {
+    writeln!(w, r#"<?xml version="1.0" encoding="UTF-8"?>"#)?;
+    writeln!(w, r#"<gexf xmlns="http://gexf.net/1.3" version="1.3">"#)?;
+    writeln!(w, r#"  <graph defaultedgetype="directed">"#)?;
+    writeln!(w, r#"    <attributes class="node">"#)?;
+    writeln!(
+        w,
+        r#"      <attribute id="depth" title="depth" type="integer"/>"#
+    )?;
+    writeln!(
+        w,
+        r#"      <attribute id="order" title="order" type="integer"/>"#
+    )?;
+    writeln!(w, "    </attributes>")?;
+
+    writeln!(w, "    <nodes>")?;
+    for (idx, article) in articles.iter().enumerate().skip(1) {
+        writeln!(
+            w,
+            r#"      <node id="{idx}" label="{}"><attvalues><attvalue for="depth" value="{}"/><attvalue for="order" value="{idx}"/></attvalues></node>"#,
+            escape(article),
+            depths[idx],
+        )?;
+    }
+    writeln!(w, "    </nodes>")?;
+
+    writeln!(w, "    <edges>")?;
+    for (id, (source, target)) in edges.iter().enumerate() {
+        writeln!(
+            w,
+            r#"      <edge id="{id}" source="{source}" target="{target}"/>"#
+        )?;
+    }
+    writeln!(w, "    </edges>")?;
+
+    writeln!(w, "  </graph>")?;
+    writeln!(w, "</gexf>")
+}
+
+fn escape(s: &str) -> String {
+    let mut out = String::with_capacity(s.len());
+    for c in s.chars() {
+        match c {
+            '&' => out.push_str("&amp;"),
+            '<' => out.push_str("&lt;"),
+            '>' => out.push_str("&gt;"),
+            '"' => out.push_str("&quot;"),
+            '\'' => out.push_str("&apos;"),
+            _ => out.push(c),
+        }
+    }
+    out
+}
@@ -0,0 +1,329 @@
+use std::{
+    collections::{HashMap, HashSet},
+    fmt,
+    sync::Mutex,
+};
+
+use clap::ValueEnum;
+
+use crate::{
+    embeddings::{self, Embeddings},
+    plugin::Plugin,
+    title,
+    wiki::{Error, Wiki},
+};
+
+/// Results per "morelike" query
+const MORELIKE_PAGE: usize = 50;
+
+/// Expanded articles between two "morelike" queries
+const MORELIKE_EVERY: usize = 20;
+
+/// How the frontier is ordered
+#[derive(Clone, Copy, Debug, PartialEq, Eq, clap::ValueEnum)]
+pub enum Strategy {
+    /// Breadth-first: level by level, finds the shortest path
+    Bfs,
+    /// Breadth-first backwards from the end, over the articles linking to each
+    Backlinks,
+    /// Best-first by the number of categories shared with the end
+    Categories,
+    /// Best-first by how close titles are to the end's in --embeddings
+    Embeddings,
+    /// Best-first towards the articles the search engine finds most like the end
+    Morelike,
+    /// Best-first by the scores --plugin gives
+    Plugin,
+    /// Random walks from the start, keeping the shortest path they find
+    RandomWalk,
+}
+
+impl Strategy {
+    pub fn name(self) -> &'static str {
+        match self {
+            Strategy::Bfs => "bfs",
+            Strategy::Backlinks => "backlinks",
+            Strategy::Categories => "categories",
+            Strategy::Embeddings => "embeddings",
+            Strategy::Morelike => "morelike",
+            Strategy::Plugin => "plugin",
+            Strategy::RandomWalk => "random-walk",
+        }
+    }
+
+    /// The heuristic guiding the search towards `end`, or `None` for the
+    /// strategies searching without one
+    pub fn heuristic<'a>(
+        self,
+        wiki: &Wiki,
+        embeddings: Option<&'a Embeddings>,
+        plugin: Option<&'a Plugin>,
+        end: &str,
+    ) -> Result<Option<Box<dyn Heuristic + 'a>>, Error> {
+        Ok(match self {
+            Strategy::Bfs | Strategy::Backlinks | Strategy::RandomWalk => None,
+            Strategy::Categories => Some(Box::new(Categories::new(wiki, end)?)),
+            Strategy::Embeddings => {
+                let Some(embeddings) = embeddings else {
+                    return Err("--strategy embeddings needs --embeddings FILE".into());
+                };
+                Some(Box::new(Similarity::new(embeddings, end)))
+            }
+            Strategy::Morelike => Some(Box::new(MoreLike::new(wiki, end)?)),
+            Strategy::Plugin => match plugin {
+                Some(plugin) if plugin.has("score") => Some(Box::new(Plugged {
+                    plugin,
+                    end: end.to_string(),
+                })),
+                Some(_) => return Err("the plugin doesn't score articles".into()),
+                None => return Err("--strategy plugin needs --plugin COMMAND".into()),
+            },
+        })
+    }
+}
+
+/// A strategy of a fallback chain, and the number of articles it may fetch
+/// before the next one takes over
+#[derive(Clone, Copy, Debug)]
+pub struct Stage {
+    pub strategy: Strategy,
+    pub budget: Option<usize>,
+}
+
+impl fmt::Display for Stage {
+    fn fmt(&self, f: &mut fmt::Formatter) -> fmt::Result {
+        f.write_str(self.strategy.name())?;
+        match self.budget {
+            Some(budget) => write!(f, ":{}", budget),
+            None => Ok(()),
+        }
+    }
+}
+
+/// Parse a stage given as `STRATEGY` or `STRATEGY:N`
+pub fn parse_stage(arg: &str) -> Result<Stage, String> {
+    let (name, budget) = match arg.split_once(':') {
+        Some((name, budget)) => {
+            let budget = budget
+                .parse()
+                .map_err(|_| format!("{} is not a number of articles", budget))?;
+            (name, Some(budget))
+        }
+        None => (arg, None),
+    };
+    let strategy = Strategy::from_str(name, true)?;
+
+    Ok(Stage { strategy, budget })
+}
+
+/// Estimates which articles lead to the end soonest
+pub trait Heuristic: Sync {
+    /// Scores of `names`, in the same order; higher is more promising
+    fn scores(&self, wiki: &Wiki, names: &[String]) -> Result<Vec<f64>, Error>;
+
+    /// Whether scores changed since this was last asked, making those of
+    /// the articles already in the frontier out of date
+    fn refreshed(&self) -> bool {
+        false
+    }
+}
+
+/// Scores articles by the number of categories they share with the end,
+/// so the search heads into the end's topic
+pub struct Categories {
+    end: HashSet<String>,
+    /// Scores of articles seen before
+    cache: Mutex<HashMap<String, f64>>,
+}
+
+impl Categories {
+    pub fn new(wiki: &Wiki, end: &str) -> Result<Self, Error> {
+        let end: HashSet<String> = wiki
+            .categories(&[end])?
+            .remove(end)
+            .unwrap_or_default()
+            .into_iter()
+            .collect();
+        if end.is_empty() {
+            eprintln!("The end article has no categories, the search is unguided");
+        }
+
+        Ok(Categories {
+            end,
+            cache: Mutex::new(HashMap::new()),
+        })
+    }
+}
+
+impl Heuristic for Categories {
+    fn scores(&self, wiki: &Wiki, names: &[String]) -> Result<Vec<f64>, Error> {
+        if self.end.is_empty() {
+            return Ok(vec![0.0; names.len()]);
+        }
+
+        let missing: Vec<&str> = {
+            let cache = self.cache.lock().unwrap();
+            names
+                .iter()
+                .filter(|name| !cache.contains_key(*name))
+                .map(String::as_str)
+                .collect()
+        };
+        if !missing.is_empty() {
+            let categories = wiki.categories(&missing)?;
+            let mut cache = self.cache.lock().unwrap();
+            for (name, cats) in categories {
+                let shared = cats.iter().filter(|c| self.end.contains(*c)).count();
+                cache.insert(name, shared as f64);
+            }
+        }
+
+        let cache = self.cache.lock().unwrap();
+        Ok(names
+            .iter()
+            .map(|name| cache.get(name).copied().unwrap_or(0.0))
+            .collect())
+    }
+}
+
+/// Scores articles by the cosine similarity of their title's word vectors to
+/// the end's, needing no requests at all
+pub struct Similarity<'a> {
+    embeddings: &'a Embeddings,
+    end: Option<Vec<f32>>,
+}
+
+impl<'a> Similarity<'a> {
+    pub fn new(embeddings: &'a Embeddings, end: &str) -> Self {
+        let end = embeddings.title_vector(end);
+        if end.is_none() {
+            eprintln!("No word of the end title has a vector, the search is unguided");
+        }
+
+        Similarity { embeddings, end }
+    }
+}
+
+impl Heuristic for Similarity<'_> {
+    fn scores(&self, _wiki: &Wiki, names: &[String]) -> Result<Vec<f64>, Error> {
+        let Some(end) = &self.end else {
+            return Ok(vec![0.0; names.len()]);
+        };
+
+        Ok(names
+            .iter()
+            .map(|name| {
+                self.embeddings
+                    .title_vector(name)
+                    .map_or(0.0, |v| embeddings::cosine(&v, end))
+            })
+            .collect())
+    }
+}
+
+/// Scores articles by their rank among those CirrusSearch finds "morelike"
+/// the end, so the search steers into the end's neighborhood. The list is
+/// extended by a page every `MORELIKE_EVERY` expanded articles while the
+/// search goes on.
+pub struct MoreLike {
+    end: String,
+    state: Mutex<MoreLikeState>,
+}
+
+struct MoreLikeState {
+    /// Rank of each article listed so far
+    ranks: HashMap<String, usize>,
+    /// Offset of the next page, `None` once the list is exhausted
+    next: Option<usize>,
+    calls: usize,
+    refreshed: bool,
+}
+
+impl MoreLike {
+    pub fn new(wiki: &Wiki, end: &str) -> Result<Self, Error> {
+        let morelike = MoreLike {
+            end: title::decode(end),
+            state: Mutex::new(MoreLikeState {
+                ranks: HashMap::new(),
+                next: Some(0),
+                calls: 0,
+                refreshed: false,
+            }),
+        };
+        morelike.extend(wiki, &mut morelike.state.lock().unwrap())?;
+
+        Ok(morelike)
+    }
+
+    /// Fetch the next page of articles like the end
+    fn extend(&self, wiki: &Wiki, state: &mut MoreLikeState) -> Result<(), Error> {
+        let Some(offset) = state.next else {
+            return Ok(());
+        };
+
+        let query = format!("morelike:{}", self.end);
+        let (limit, offset_str) = (MORELIKE_PAGE.to_string(), offset.to_string());
+        let res = wiki.api(&[
+            ("action", "query"),
+            ("list", "search"),
+            ("srsearch", &query),
+            ("srnamespace", "0"),
+            ("srlimit", &limit),
+            ("sroffset", &offset_str),
+            ("srinfo", ""),
+            ("srprop", ""),
+        ])?;
+
+        let results = res["query"]["search"].as_array().into_iter().flatten();
+        for (rank, t) in (offset..).zip(results.filter_map(|r| r["title"].as_str())) {
+            state
+                .ranks
+                .entry(title::encode(&title::underscored(t)))
+                .or_insert(rank);
+        }
+        state.next = res["continue"]["sroffset"]
+            .as_u64()
+            .map(|next| next as usize);
+
+        Ok(())
+    }
+}
+
+impl Heuristic for MoreLike {
+    fn scores(&self, wiki: &Wiki, names: &[String]) -> Result<Vec<f64>, Error> {
+        let mut state = self.state.lock().unwrap();
+
+        state.calls += 1;
+        if state.calls.is_multiple_of(MORELIKE_EVERY) && state.next.is_some() {
+            self.extend(wiki, &mut state)?;
+            state.refreshed = true;
+        }
+
+        // Listed articles first, best ranked first
+        Ok(names
+            .iter()
+            .map(|name| {
+                state
+                    .ranks
+                    .get(name)
+                    .map_or(0.0, |&rank| 1.0 / (1.0 + rank as f64))
+            })
+            .collect())
+    }
+
+    fn refreshed(&self) -> bool {
+        std::mem::take(&mut self.state.lock().unwrap().refreshed)
+    }
+}
+
+/// Scores articles by asking a plugin
+pub struct Plugged<'a> {
+    plugin: &'a Plugin,
+    end: String,
+}
+
+impl Heuristic for Plugged<'_> {
+    fn scores(&self, _wiki: &Wiki, names: &[String]) -> Result<Vec<f64>, Error> {
+        self.plugin.scores(&self.end, names)
+    }
+}
@@ -0,0 +1,290 @@
+use std::{fs, time::Duration};
+
+use rusqlite::{self as sql, params, OptionalExtension};
+
+use crate::{
+    heuristic::Strategy, path::Path, project::Project, quota, search::Explored, title, wiki::Error,
+};
+
+const SCHEMA: &str = "
+CREATE TABLE IF NOT EXISTS searches (
+    id INTEGER PRIMARY KEY,
+    searched_at TEXT NOT NULL,
+    host TEXT NOT NULL,
+    start TEXT NOT NULL,
+    \"end\" TEXT NOT NULL,
+    strategy TEXT NOT NULL,
+    path TEXT,
+    length INTEGER,
+    requests INTEGER NOT NULL,
+    duration_ms INTEGER NOT NULL
+);
+";
+
+const COLUMNS: &str =
+    "id, searched_at, host, start, \"end\", strategy, path, requests, duration_ms";
+
+/// A completed search
+pub struct Search {
+    pub project: Project,
+    pub start: String,
+    pub end: String,
+    /// Strategies as given to --strategy, e.g. `morelike:200,bfs`, or
+    /// `race bfs,backlinks` for --race
+    pub strategy: String,
+    /// The path found, `None` if there was none
+    pub path: Option<Path>,
+    pub requests: u32,
+    pub duration: Duration,
+}
+
+impl Search {
+    /// A breadth-first search a subcommand ran among others, which counts
+    /// its own requests by level as the others make theirs at the same time
+    pub fn bfs(
+        project: Project,
+        start: &str,
+        end: &str,
+        explored: &Explored,
+        path: Option<Path>,
+        duration: Duration,
+    ) -> Self {
+        Search {
+            project,
+            start: start.to_string(),
+            end: end.to_string(),
+            strategy: Strategy::Bfs.name().to_string(),
+            path,
+            requests: explored.levels.iter().map(|level| level.requests).sum(),
+            duration,
+        }
+    }
+}
+
+/// A search read back from the history
+pub struct Entry {
+    pub id: i64,
+    pub searched_at: String,
+    pub start: String,
+    pub end: String,
+    pub strategy: String,
+    pub path: Option<Path>,
+    pub requests: u32,
+    pub duration: Duration,
+}
+
+/// Which searches to list, latest first
+pub struct Filter {
+    /// Only searches whose start title contains this
+    pub start: Option<String>,
+    /// Only searches whose end title contains this
+    pub end: Option<String>,
+    /// Only searches that found a path
+    pub found: bool,
+    pub limit: usize,
+}
+
+/// Every search run from the command line, kept in a SQLite database in the
+/// cache directory so results outlive the terminal they were printed in.
+///
+/// Each search is a row of `searches`, its path a JSON array of titles in URL
+/// form, or NULL if none was found, and its `length` the number of articles
+/// on it, as printed.
+pub struct History {
+    conn: sql::Connection,
+}
+
+impl History {
+    pub fn open() -> Result<Self, Error> {
+        let dir = quota::cache_dir().ok_or("no cache directory to keep the history in")?;
+        fs::create_dir_all(&dir)?;
+        let path = dir.join("history.sqlite");
+
+        let conn = sql::Connection::open(&path)
+            .and_then(|conn| conn.execute_batch(SCHEMA).map(|()| conn))
+            .map_err(|err| format!("{}: {}", path.display(), err))?;
+
+        Ok(History { conn })
+    }
+
+    /// Add `search`, returning its ID
+    pub fn record(&self, search: &Search) -> sql::Result<i64> {
+        let path = search
+            .path
+            .as_ref()
+            .map(|p| serde_json::Value::from(p.titles()).to_string());
+
+        self.conn.execute(
+            "INSERT INTO searches
+                (searched_at, host, start, \"end\", strategy, path, length, requests, duration_ms)
+                VALUES (?1, ?2, ?3, ?4, ?5, ?6, ?7, ?8, ?9)",
+            params![
+                jiff::Timestamp::now().to_string(),
+                search.project.host(),
+                search.start,
+                search.end,
+                search.strategy,
+                path,
+                search.path.as_ref().map(|p| p.len() as i64),
+                search.requests,
+                search.duration.as_millis() as i64,
+            ],
+        )?;
+
+        Ok(self.conn.last_insert_rowid())
+    }
+
+    /// The searches on `project` matching `filter`, latest first
+    pub fn list(&self, project: Project, filter: &Filter) -> sql::Result<Vec<Entry>> {
+        // Titles are stored in URL form, whose `%` and `_` are no wildcards
+        // here, and LIKE ignores ASCII case
+        let pattern = |t: &Option<String>| {
+            t.as_ref().map(|t| {
+                let escaped = title::normalize(t)
+                    .replace('\\', "\\\\")
+                    .replace('%', "\\%")
+                    .replace('_', "\\_");
+                format!("%{}%", escaped)
+            })
+        };
+
+        let mut stmt = self.conn.prepare(&format!(
+            "SELECT {COLUMNS} FROM searches
+                WHERE host = ?1
+                    AND (?2 IS NULL OR start LIKE ?2 ESCAPE '\\')
+                    AND (?3 IS NULL OR \"end\" LIKE ?3 ESCAPE '\\')
+                    AND (NOT ?4 OR path IS NOT NULL)
+                ORDER BY id DESC
+                LIMIT ?5"
+        ))?;
+        let rows = stmt.query_map(
+            params![
+                project.host(),
+                pattern(&filter.start),
+                pattern(&filter.end),
+                filter.found,
+                filter.limit as i64,
+            ],
+            entry,
+        )?;
+
+        rows.collect()
+    }
+
+    /// The search with ID `id`, if there is one
+    pub fn get(&self, id: i64) -> sql::Result<Option<Entry>> {
+        self.conn
+            .query_row(
+                &format!("SELECT {COLUMNS} FROM searches WHERE id = ?1"),
+                params![id],
+                entry,
+            )
+            .optional()
+    }
+}
+
+fn entry(row: &sql::Row) -> sql::Result<Entry> {
+    let path: Option<String> = row.get(6)?;
+    let duration_ms: i64 = row.get(8)?;
+
+    Ok(Entry {
+        id: row.get(0)?,
+        searched_at: row.get(1)?,
+        start: row.get(3)?,
+        end: row.get(4)?,
+        strategy: row.get(5)?,
+        path: path
+            .and_then(|p| serde_json::from_str(&p).ok())
+            .map(Path::new),
+        requests: row.get(7)?,
+        duration: Duration::from_millis(duration_ms.max(0) as u64),
+    })
+}
+
+/// Add `search` to the history, telling why if it can't be
+pub fn record(search: &Search) {
+    let result = History::open().and_then(|history| {
+        history.record(search)?;
+        Ok(())
+    });
+    if let Err(err) = result {
+        eprintln!("Not recorded in the history: {}", err);
+    }
+}
+
+/// Print the searches on `project` matching `filter`, or all about the one
+/// with ID `show`
+pub fn run(project: Project, filter: &Filter, show: Option<i64>) {
+    if let Err(err) = history(project, filter, show) {
+        eprintln!("{}", err);
+    }
+}
+
+fn history(project: Project, filter: &Filter, show: Option<i64>) -> Result<(), Error> {
+    let history = History::open()?;
+
+    if let Some(id) = show {
+        let entry = history
+            .get(id)?
+            .ok_or_else(|| format!("No search {} in the history", id))?;
+        print_entry(&entry);
+        return Ok(());
+    }
+
+    let entries = history.list(project, filter)?;
+    if entries.is_empty() {
+        println!("No searches in the history");
+    }
+    // Oldest first, so the latest is next to the prompt
+    for entry in entries.iter().rev() {
+        let took = jiff::SignedDuration::from_secs_f64(entry.duration.as_secs_f64());
+        let result = match &entry.path {
+            Some(path) => format!("length {}", path.len()),
+            None => "no path".to_string(),
+        };
+        println!(
+            "{:>4}  {}  {} -> {}: {}, {} requests, took {took:#}",
+            entry.id,
+            local_time(&entry.searched_at),
+            entry.start,
+            entry.end,
+            result,
+            entry.requests
+        );
+    }
+
+    Ok(())
+}
+
+fn print_entry(entry: &Entry) {
+    println!(
+        "Search {} of {} to {}, {} with {}",
+        entry.id,
+        entry.start,
+        entry.end,
+        local_time(&entry.searched_at),
+        entry.strategy
+    );
+    match &entry.path {
+        Some(path) => {
+            println!("Path: {:?}", path);
+            println!("Length: {}", path.len());
+        }
+        None => println!("No path found"),
+    }
+    println!("Requests: {}", entry.requests);
+
+    let took = jiff::SignedDuration::from_secs_f64(entry.duration.as_secs_f64());
+    println!("Took {took:#}");
+}
+
+/// A stored timestamp in the local time zone, to the minute
+fn local_time(stored: &str) -> String {
+    match stored.parse::<jiff::Timestamp>() {
+        Ok(time) => time
+            .to_zoned(jiff::tz::TimeZone::system())
+            .strftime("%Y-%m-%d %H:%M")
+            .to_string(),
+        Err(_) => stored.to_string(),
+    }
+}
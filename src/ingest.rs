@@ -0,0 +1,105 @@
+use std::{
+    fs::File,
+    io::{self, BufRead, BufReader, BufWriter},
+    path::{Path, PathBuf},
+};
+
+use scraper as sc;
+use serde_json::Value;
+
+use crate::{project::Project, store, title, wiki};
+
+/// Extract the links of every article in Wikimedia Enterprise HTML dumps into
+/// the link store at `output`.
+///
+/// Dumps are NDJSON, one article per line, and can be read from stdin with
+/// "-", e.g. straight out of `tar -xzOf`.
+pub fn run(project: Project, dumps: &[PathBuf], output: &Path) {
+    let mut writer = match File::create(output).and_then(|f| store::Writer::new(BufWriter::new(f)))
+    {
+        Ok(writer) => writer,
+        Err(err) => {
+            eprintln!("{}: {}", output.display(), err);
+            return;
+        }
+    };
+
+    let (mut articles, mut redirects) = (0, 0);
+    for dump in dumps {
+        let reader: Box<dyn BufRead> = if dump.as_os_str() == "-" {
+            Box::new(io::stdin().lock())
+        } else {
+            match File::open(dump) {
+                Ok(file) => Box::new(BufReader::new(file)),
+                Err(err) => {
+                    eprintln!("{}: {}", dump.display(), err);
+                    continue;
+                }
+            }
+        };
+
+        for (n, line) in reader.lines().enumerate() {
+            let line = match line {
+                Ok(line) => line,
+                Err(err) => {
+                    eprintln!("{}: {}", dump.display(), err);
+                    break;
+                }
+            };
+            if line.trim().is_empty() {
+                continue;
+            }
+            let article: Value = match serde_json::from_str(&line) {
+                Ok(article) => article,
+                Err(err) => {
+                    eprintln!("{}:{}: {}", dump.display(), n + 1, err);
+                    continue;
+                }
+            };
+
+            // Only articles, the dumps of other namespaces are separate but
+            // may be fed in by mistake
+            if article["namespace"]["identifier"].as_u64().unwrap_or(0) != 0 {
+                continue;
+            }
+            let (Some(name), Some(html)) = (
+                article["name"].as_str(),
+                article["article_body"]["html"].as_str(),
+            ) else {
+                eprintln!("{}:{}: not an article", dump.display(), n + 1);
+                continue;
+            };
+
+            let name = title::encode(&title::underscored(name));
+            let document = sc::Html::parse_document(wiki::content(html));
+            let links = wiki::relative_links(project, &document, |link| {
+                link != name && project.is_article(link)
+            });
+            let mut written = writer.article(&name, &links);
+            for redirect in article["redirects"].as_array().into_iter().flatten() {
+                if let Some(from) = redirect["name"].as_str() {
+                    written = written.and_then(|_| {
+                        writer.redirect(&title::encode(&title::underscored(from)), &name)
+                    });
+                    redirects += 1;
+                }
+            }
+            if let Err(err) = written {
+                eprintln!("{}: {}", output.display(), err);
+                return;
+            }
+            articles += 1;
+        }
+    }
+
+    if let Err(err) = writer.finish() {
+        eprintln!("{}: {}", output.display(), err);
+        return;
+    }
+    println!(
+        "Ingested {} articles and {} redirects into {}",
+        articles,
+        redirects,
+        output.display()
+    );
+}
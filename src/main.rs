@@ -1,147 +1,984 @@
-use std::{
-    collections::HashMap,
-    thread,
-    time::{Duration, Instant},
-};
+mod audit;
+mod auth;
+mod batch;
+mod bench;
+mod congestion;
+mod demo;
+mod dns;
+mod embeddings;
+mod estimate;
+mod events;
+mod exclude;
+mod explore;
+mod graph;
+mod heuristic;
+mod history;
+mod ingest;
+mod matrix;
+mod mem;
+mod path;
+mod pause;
+mod plugin;
+mod profile;
+mod project;
+mod puzzle;
+mod quota;
+mod race;
+mod repl;
+mod roundtrip;
+mod sample;
+mod search;
+mod spill;
+mod sqlite;
+mod store;
+mod title;
+mod transport;
+mod visited;
+mod wiki;
+mod zim;
+
+use std::{path::PathBuf, time::Instant};
 
 use clap::{self, Parser};
 use jiff;
-use reqwest as rw;
-use scraper as sc;
+use serde_json::json;
 
-const DEFAULT_MAX_DEPTH: u32 = 25;
+use events::Events;
+use wiki::Wiki;
 
-const REQ_WAIT_SECS: f32 = 0.5;
+const DEFAULT_MAX_DEPTH: u32 = 25;
 
 #[derive(clap::Parser, Debug)]
-#[command(version, about, long_about = None)]
+#[command(
+    version,
+    about,
+    long_about = None,
+    subcommand_negates_reqs = true,
+    override_usage = "wiki-path [OPTIONS] <START> <END>\n       wiki-path [OPTIONS] <COMMAND>"
+)]
 struct Cli {
-    start: String,
-    end: String,
+    #[command(subcommand)]
+    command: Option<Command>,
+
+    /// Title, article URL, Wikidata item (e.g. Q937) or "random"
+    #[arg(required_unless_present = "demo", value_parser = title::parse_arg)]
+    start: Option<title::Arg>,
+    /// Title, article URL, Wikidata item (e.g. Q937) or "random"
+    #[arg(required_unless_present_any = ["demo", "end_category"], value_parser = title::parse_arg)]
+    end: Option<title::Arg>,
+
+    /// Wiki to search
+    #[arg(long, value_enum, global = true, default_value_t = project::Project::Wikipedia)]
+    project: project::Project,
 
     /// Print article name and depth for each searched article
-    #[arg(short, long)]
+    #[arg(short, long, global = true)]
     verbose: bool,
 
+    /// Only print the path, joined by SEP, on a single line
+    #[arg(short, long, conflicts_with = "verbose")]
+    quiet: bool,
+
+    /// Print the result as a JSON object
+    #[arg(long, conflicts_with_all = ["quiet", "verbose"])]
+    json: bool,
+
+    /// Separator between articles in quiet mode
+    #[arg(long, value_name = "SEP", default_value = " ", requires = "quiet")]
+    separator: String,
+
     /// Maximum depth to search
-    #[arg(short = 'd', long, value_name = "DEPTH", default_value_t = DEFAULT_MAX_DEPTH)]
+    #[arg(short = 'd', long, value_name = "DEPTH", global = true, default_value_t = DEFAULT_MAX_DEPTH)]
     max_depth: u32,
 
+    /// Order in which articles are expanded: bfs, backlinks, categories,
+    /// embeddings, morelike, plugin or random-walk; only bfs finds the
+    /// shortest path. A list such as morelike:200,bfs falls back to the next
+    /// strategy once one has fetched its N articles without finding a path
+    #[arg(
+        long,
+        value_name = "STRATEGY[:N]",
+        value_parser = heuristic::parse_stage,
+        value_delimiter = ',',
+        global = true,
+        default_value = "bfs"
+    )]
+    strategy: Vec<heuristic::Stage>,
+
+    /// Search with two strategies at once, e.g. bfs,backlinks, keeping the
+    /// path of whichever finds one first
+    #[arg(
+        long,
+        value_name = "A,B",
+        value_delimiter = ',',
+        conflicts_with_all = ["strategy", "all", "all_shortest", "save_visited", "seed_visited"]
+    )]
+    race: Vec<heuristic::Strategy>,
+
+    /// Program filtering links and scoring articles for --strategy plugin, see the README
+    #[arg(long, value_name = "COMMAND", global = true)]
+    plugin: Option<String>,
+
+    /// Word vectors (GloVe or word2vec text format) for --strategy embeddings
+    #[arg(long, value_name = "FILE", global = true)]
+    embeddings: Option<PathBuf>,
+
     /// Find all paths up to DEPTH
     #[arg(short, long)]
     all: bool,
+
+    /// Find every shortest path, listing up to --max-paths of them
+    #[arg(long, conflicts_with = "all")]
+    all_shortest: bool,
+
+    /// Number of paths listed by --all-shortest
+    #[arg(
+        long,
+        value_name = "N",
+        default_value_t = 10,
+        requires = "all_shortest"
+    )]
+    max_paths: usize,
+
+    /// Write the explored graph to FILE (GEXF if it ends in .gexf, GraphML otherwise)
+    #[arg(long, value_name = "FILE")]
+    export_graph: Option<PathBuf>,
+
+    /// Write the articles the search discovered, with their depths, to FILE
+    #[arg(long, value_name = "FILE")]
+    save_visited: Option<PathBuf>,
+
+    /// Carry on from articles saved with --save-visited by a search from the same start
+    #[arg(long, value_name = "FILE")]
+    seed_visited: Option<PathBuf>,
+
+    /// Don't record searches in the history
+    #[arg(long, global = true)]
+    no_history: bool,
+
+    /// Record every discovered article and link into the SQLite database FILE as the search runs
+    #[arg(long, value_name = "FILE", global = true)]
+    export_sqlite: Option<PathBuf>,
+
+    /// Write search events as JSON Lines to FILE ("-" for stdout)
+    #[arg(long, value_name = "FILE", global = true)]
+    events: Option<PathBuf>,
+
+    /// Trace every request, link kept or dropped and article queued to FILE, as JSON Lines
+    #[arg(long, value_name = "FILE", global = true)]
+    audit: Option<PathBuf>,
+
+    /// Log every HTTP request (URL, status, bytes, wait and latency) to FILE
+    #[arg(long, value_name = "FILE", global = true)]
+    request_log: Option<PathBuf>,
+
+    /// Keep memory use under MiB: near it spill expanded articles to disk
+    /// and prune the frontier, at it stop growing the frontier
+    #[arg(long, value_name = "MiB", global = true)]
+    max_memory: Option<u64>,
+
+    /// Also accept links to END that differ in case or redirect to it
+    #[arg(long)]
+    fuzzy: bool,
+
+    /// End at any article in this category, e.g. "Category:Birds", instead of END
+    #[arg(
+        long,
+        value_name = "CATEGORY",
+        value_parser = title::parse_category,
+        conflicts_with_all = ["end", "fuzzy", "estimate"]
+    )]
+    end_category: Option<title::Arg>,
+
+    /// Only predict how many requests and how long the search would take
+    #[arg(long)]
+    estimate: bool,
+
+    /// Read articles from a local Kiwix ZIM archive instead of the live wiki
+    #[arg(long, value_name = "FILE", global = true)]
+    zim: Option<PathBuf>,
+
+    /// Read links from a link store written by `ingest` instead of the live wiki
+    #[arg(long, value_name = "FILE", global = true, conflicts_with = "zim")]
+    link_store: Option<PathBuf>,
+
+    /// Search a small built-in wiki served locally, from Cat to Philosophy
+    /// unless START and END are given
+    #[arg(long, global = true, conflicts_with_all = ["zim", "link_store"])]
+    demo: bool,
+
+    /// Also follow links to pages in these namespaces, e.g. Category,Portal
+    #[arg(long, value_name = "NS", value_delimiter = ',', global = true)]
+    include_namespaces: Vec<String>,
+
+    /// Only follow links in the lead section of each article, before the first heading
+    #[arg(long, global = true)]
+    lead_only: bool,
+
+    /// Only follow the first N links of each article
+    #[arg(long, value_name = "N", global = true)]
+    max_links_per_page: Option<usize>,
+
+    /// Only search through articles tagged by this WikiProject, e.g. Medicine
+    #[arg(long, value_name = "NAME", global = true)]
+    wikiproject: Option<String>,
+
+    /// Don't search through articles in these categories, e.g.
+    /// "Category:Stubs,Category:Disambiguation pages"
+    #[arg(
+        long,
+        value_name = "CATEGORY",
+        value_delimiter = ',',
+        value_parser = title::parse_category,
+        global = true,
+        conflicts_with_all = ["zim", "link_store"]
+    )]
+    exclude_category: Vec<title::Arg>,
+
+    /// Also trust the certificate authorities in this PEM file, e.g. a proxy's
+    #[arg(long, value_name = "FILE", global = true)]
+    ca_bundle: Option<PathBuf>,
+
+    /// With a token stored by `auth login`, fetch articles over two lanes,
+    /// each within its own rate limit: anonymous requests for pages, and
+    /// authenticated ones to the REST API of api.wikimedia.org
+    #[arg(long, global = true, conflicts_with_all = ["demo", "zim", "link_store"])]
+    dual_lane: bool,
+
+    /// Don't verify TLS certificates at all
+    #[arg(long, global = true)]
+    insecure_skip_verify: bool,
+
+    /// Give up connecting to the server after SECS seconds
+    #[arg(long, value_name = "SECS", value_parser = transport::parse_secs, global = true)]
+    connect_timeout: Option<std::time::Duration>,
+
+    /// Give up on a request after SECS seconds [default: 30]
+    #[arg(long, value_name = "SECS", value_parser = transport::parse_secs, global = true)]
+    timeout: Option<std::time::Duration>,
+
+    /// Try addresses of this family first
+    #[arg(long, value_name = "FAMILY", global = true)]
+    prefer_ip: Option<dns::IpFamily>,
+
+    /// Resolve host names with this DNS server, e.g. 1.1.1.1 or [2606:4700::1111]:53
+    #[arg(long, value_name = "ADDR", value_parser = dns::parse_server, global = true)]
+    dns_server: Option<std::net::SocketAddr>,
+
+    /// Print where the time went (rate-limit waits, network, HTML parsing, each
+    /// depth of the search) to stderr
+    #[arg(long, global = true)]
+    timings: bool,
+
+    /// Sample where the CPU time goes and write it to FILE, as a flamegraph if
+    /// it ends in .svg and a pprof profile otherwise (needs the pprof feature)
+    #[arg(long, value_name = "FILE", global = true)]
+    cpu_profile: Option<PathBuf>,
+}
+
+#[derive(clap::Subcommand, Debug)]
+enum Command {
+    /// Manage the personal API token requests are authenticated with
+    Auth {
+        #[command(subcommand)]
+        action: auth::Action,
+    },
+    /// Search several pairs at once, sharing the rate limit and link cache
+    Batch {
+        /// File with one whitespace-separated start/end pair per line
+        file: PathBuf,
+
+        /// Number of searches to run at the same time
+        #[arg(short, long, default_value_t = 4, value_parser = clap::value_parser!(u32).range(1..))]
+        jobs: u32,
+    },
+    /// Run a fixed set of searches and report their cost
+    Bench {
+        /// File with one whitespace-separated start/end pair per line to
+        /// search instead
+        #[arg(long, value_name = "FILE")]
+        pairs: Option<PathBuf>,
+
+        /// Number of articles each search may fetch
+        #[arg(long, value_name = "N", default_value_t = 500)]
+        budget: usize,
+    },
+    /// Search outward from START with no end, reporting the depth reached
+    Explore {
+        /// Title, article URL, Wikidata item (e.g. Q937) or "random"
+        #[arg(value_parser = title::parse_arg)]
+        start: title::Arg,
+
+        /// Number of articles to fetch
+        #[arg(long, value_name = "N")]
+        budget: usize,
+    },
+    /// List past searches, latest last, or show one of them again
+    History {
+        /// Only searches from an article whose title contains TEXT
+        #[arg(long, value_name = "TEXT")]
+        start: Option<String>,
+
+        /// Only searches to an article whose title contains TEXT
+        #[arg(long, value_name = "TEXT")]
+        end: Option<String>,
+
+        /// Only searches that found a path
+        #[arg(long)]
+        found: bool,
+
+        /// List the latest N searches
+        #[arg(short = 'n', long, value_name = "N", default_value_t = 20)]
+        limit: usize,
+
+        /// Print the whole result of the search with this ID
+        #[arg(long, value_name = "ID", conflicts_with_all = ["start", "end", "found"])]
+        show: Option<i64>,
+    },
+    /// Extract the links of Wikimedia Enterprise HTML dumps (NDJSON) into a link store
+    Ingest {
+        /// Dump files, "-" for stdin
+        #[arg(required = true)]
+        dumps: Vec<PathBuf>,
+
+        /// Link store to write, for use with --link-store
+        #[arg(short, long, value_name = "FILE")]
+        output: PathBuf,
+    },
+    /// Write the distances between every pair of the listed articles as a CSV matrix
+    Matrix {
+        /// File with one article per line
+        file: PathBuf,
+
+        /// CSV file to write instead of stdout
+        #[arg(short, long, value_name = "FILE")]
+        output: Option<PathBuf>,
+    },
+    /// Find a start/end pair whose shortest path has exactly N links
+    Puzzle {
+        /// Number of links between start and end
+        #[arg(long, value_name = "N", value_parser = clap::value_parser!(u32).range(1..))]
+        distance: u32,
+
+        /// Start from this article instead of a random one
+        #[arg(long, value_name = "ARTICLE", value_parser = title::parse_arg)]
+        start: Option<title::Arg>,
+
+        /// Also print the path between the two
+        #[arg(long)]
+        solution: bool,
+    },
+    /// Answer `find A B` queries interactively, keeping fetched links cached
+    Repl,
+    /// Suggest the next link to click while racing to END by hand
+    Race {
+        #[arg(value_parser = title::parse_arg)]
+        end: title::Arg,
+
+        /// Only print the next click, not the rest of the path
+        #[arg(long)]
+        hint: bool,
+    },
+    /// Search from A to B and from B back to A
+    Roundtrip {
+        /// Title, article URL, Wikidata item (e.g. Q937) or "random"
+        #[arg(value_parser = title::parse_arg)]
+        a: title::Arg,
+        /// Title, article URL, Wikidata item (e.g. Q937) or "random"
+        #[arg(value_parser = title::parse_arg)]
+        b: title::Arg,
+    },
+    /// Crawl the neighborhood of TITLE and report its out-degrees, branching factor and hubs
+    Sample {
+        /// Title, article URL, Wikidata item (e.g. Q937) or "random"
+        #[arg(value_parser = title::parse_arg)]
+        title: title::Arg,
+
+        /// Number of links from TITLE the neighborhood extends to
+        #[arg(long, default_value_t = 2, value_parser = clap::value_parser!(u32).range(1..))]
+        depth: u32,
+
+        /// Fetch at most N articles
+        #[arg(long, value_name = "N")]
+        budget: Option<usize>,
+    },
 }
 
 fn main() {
     let c = Cli::parse();
 
-    let start_time = Instant::now();
+    let events = match Events::open(c.events.as_deref()) {
+        Ok(events) => events,
+        Err(err) => {
+            eprintln!("{}", err);
+            return;
+        }
+    };
 
-    let req_wait = Duration::from_secs_f32(REQ_WAIT_SECS);
-    let mut prev_req = Instant::now()
-        .checked_sub(req_wait)
-        .unwrap_or_else(|| Instant::now());
+    let cpu_profile = match c.cpu_profile.as_deref().map(profile::CpuProfile::start) {
+        Some(Ok(cpu_profile)) => Some(cpu_profile),
+        Some(Err(err)) => {
+            eprintln!("{}", err);
+            return;
+        }
+        None => None,
+    };
 
-    let mut articles = vec![String::new(), c.start];
-    let mut article_parent = HashMap::from([(1, 0)]);
+    let start_time = Instant::now();
+    let transport = transport::Transport {
+        ca_bundle: c.ca_bundle.clone(),
+        insecure: c.insecure_skip_verify,
+        connect_timeout: c.connect_timeout,
+        timeout: c.timeout,
+        prefer: c.prefer_ip,
+        dns_server: c.dns_server,
+    };
+    if transport.insecure {
+        eprintln!(
+            "TLS certificates are not verified, anyone on the network can tamper with results"
+        );
+    }
+    let client = match transport.client() {
+        Ok(client) => client,
+        Err(err) => {
+            eprintln!("{}", err);
+            return;
+        }
+    };
+
+    let mut wiki = Wiki::new(c.project, client);
+    wiki.set_lead_only(c.lead_only);
+    wiki.include_namespaces(c.include_namespaces.clone());
+    if let Some(path) = &c.request_log {
+        if let Err(err) = wiki.log_requests(path) {
+            eprintln!("{}", err);
+            return;
+        }
+    }
+    if let Some(path) = &c.audit {
+        if let Err(err) = wiki.audit_to(path) {
+            eprintln!("{}: {}", path.display(), err);
+            return;
+        }
+    }
+    // Only requests to the live wiki need it
+    let online = !c.demo && c.zim.is_none() && c.link_store.is_none();
+    if online
+        && !matches!(
+            c.command,
+            Some(Command::Auth { .. } | Command::History { .. } | Command::Ingest { .. })
+        )
+    {
+        if let Some(token) = auth::token(c.verbose) {
+            wiki.set_token(token);
+        }
+        if c.dual_lane {
+            if let Err(err) = wiki.split_lanes() {
+                eprintln!("{}", err);
+                return;
+            }
+        }
+    }
+    if c.demo {
+        match demo::Server::start() {
+            Ok(server) => wiki.serve_from(server.base().to_string()),
+            Err(err) => {
+                eprintln!("Demo wiki: {}", err);
+                return;
+            }
+        }
+    }
+    let offline = match (&c.zim, &c.link_store) {
+        (Some(path), _) => Some((path, zim::Zim::open(path).map(wiki::Offline::Zim))),
+        (_, Some(path)) => Some((path, store::LinkStore::open(path).map(wiki::Offline::Store))),
+        (None, None) => None,
+    };
+    if let Some((path, source)) = offline {
+        match source {
+            Ok(source) => wiki.go_offline(source),
+            Err(err) => {
+                eprintln!("{}: {}", path.display(), err);
+                return;
+            }
+        }
+    }
+
+    if c.max_memory.is_some() && mem::rss().is_none() {
+        eprintln!("Memory use can't be measured on this platform, --max-memory has no effect");
+    }
 
-    let mut curr_idx = 0;
-    let mut level_len;
-    let mut next_level_len = 1;
+    let scope = match &c.wikiproject {
+        Some(name) => match wiki.wikiproject_articles(name) {
+            Ok(articles) => Some(articles),
+            Err(err) => {
+                eprintln!("{}", err);
+                return;
+            }
+        },
+        None => None,
+    };
+
+    let exclude = match c
+        .exclude_category
+        .iter()
+        .map(|category| category.resolve(&wiki))
+        .collect::<Result<Vec<_>, _>>()
+    {
+        Ok(categories) => (!categories.is_empty()).then(|| exclude::Exclude::new(categories)),
+        Err(err) => {
+            eprintln!("{}", err);
+            return;
+        }
+    };
+
+    let db = match &c.export_sqlite {
+        Some(path) => match sqlite::Db::open(path) {
+            Ok(db) => Some(db),
+            Err(err) => {
+                eprintln!("{}: {}", path.display(), err);
+                return;
+            }
+        },
+        None => None,
+    };
+
+    let embeddings = match &c.embeddings {
+        Some(path) => match embeddings::Embeddings::load(path) {
+            Ok(embeddings) => Some(embeddings),
+            Err(err) => {
+                eprintln!("{}: {}", path.display(), err);
+                return;
+            }
+        },
+        None => None,
+    };
+
+    let plugin = match &c.plugin {
+        Some(command) => match plugin::Plugin::start(command) {
+            Ok(plugin) => Some(plugin),
+            Err(err) => {
+                eprintln!("{}", err);
+                return;
+            }
+        },
+        None => None,
+    };
+
+    // Searches go on as before if it can't be paused
+    let pause = pause::Pause::listen()
+        .map_err(|err| eprintln!("Can't listen for SIGUSR1: {}", err))
+        .ok();
+
+    let opts = search::Options {
+        max_depth: c.max_depth,
+        all: c.all,
+        all_shortest: c.all_shortest,
+        verbose: c.verbose,
+        record_edges: c.export_graph.is_some(),
+        budget: None,
+        max_memory: c.max_memory.map(|mib| mib * 1024 * 1024),
+        max_links: c.max_links_per_page,
+        scope: scope.as_ref(),
+        exclude: exclude.as_ref(),
+        db: db.as_ref(),
+        embeddings: embeddings.as_ref(),
+        plugin: plugin.as_ref(),
+        seed: None,
+        cancel: None,
+        pause: pause.as_ref(),
+        history: !c.no_history && !c.demo,
+    };
+
+    match &c.command {
+        Some(Command::Auth { action }) => auth::run(action),
+        Some(Command::Batch { file, jobs }) => {
+            batch::run(&mut wiki, &events, &opts, file, *jobs as usize)
+        }
+        Some(Command::Bench { pairs, budget }) => {
+            bench::run(&wiki, &events, &opts, c.demo, pairs.as_deref(), *budget)
+        }
+        Some(Command::Explore { start, budget }) => {
+            explore::run(&wiki, &events, &opts, start, *budget)
+        }
+        Some(Command::History {
+            start,
+            end,
+            found,
+            limit,
+            show,
+        }) => {
+            let filter = history::Filter {
+                start: start.clone(),
+                end: end.clone(),
+                found: *found,
+                limit: *limit,
+            };
+            history::run(c.project, &filter, *show)
+        }
+        Some(Command::Ingest { dumps, output }) => ingest::run(c.project, dumps, output),
+        Some(Command::Matrix { file, output }) => {
+            matrix::run(&mut wiki, &events, &opts, file, output.as_deref())
+        }
+        Some(Command::Puzzle {
+            distance,
+            start,
+            solution,
+        }) => puzzle::run(&wiki, &events, &opts, start.as_ref(), *distance, *solution),
+        Some(Command::Race { end, hint }) => race::run(&mut wiki, &events, &opts, end, *hint),
+        Some(Command::Repl) => repl::run(&mut wiki, &events, &opts),
+        Some(Command::Roundtrip { a, b }) => roundtrip::run(&mut wiki, &events, &opts, a, b),
+        Some(Command::Sample {
+            title,
+            depth,
+            budget,
+        }) => sample::run(&mut wiki, &events, &opts, title, *depth, *budget),
+        None => {
+            // Later strategies of a chain go over articles the earlier ones
+            // fetched, and racing ones over those the other did
+            if c.strategy.len() > 1 || !c.race.is_empty() {
+                wiki.enable_cache();
+            }
+            find(&c, &wiki, &events, &opts)
+        }
+    }
 
-    for depth in 0..(c.max_depth + 1) {
-        level_len = next_level_len;
-        next_level_len = 0;
+    if c.timings {
+        wiki.timings().print(start_time.elapsed());
+    }
+    if let Some(Err(err)) = cpu_profile.map(profile::CpuProfile::finish) {
+        eprintln!("Writing the CPU profile: {}", err);
+    }
+}
 
-        let end_idx = curr_idx + level_len;
-        while curr_idx < end_idx {
-            curr_idx += 1;
+fn find(c: &Cli, wiki: &Wiki, events: &Events, opts: &search::Options) {
+    // Both are required unless a subcommand or --demo is given, END unless
+    // --end-category is
+    let demo_pair = [demo::START, demo::END].map(|t| title::Arg::Title(t.to_string()));
+    let (start, end) = match (&c.start, c.end.as_ref().or(c.end_category.as_ref())) {
+        (Some(start), Some(end)) => (start, end),
+        (_, end) => (&demo_pair[0], end.unwrap_or(&demo_pair[1])),
+    };
+    let (start, end) = match (start.resolve(wiki), end.resolve(wiki)) {
+        (Ok(start), Ok(end)) => (start, end),
+        (Err(err), _) | (_, Err(err)) => {
+            eprintln!("{}", err);
+            return;
+        }
+    };
+    let (start, end) = (&start, &end);
 
-            let article = &articles[curr_idx];
+    if c.estimate {
+        estimate::run(wiki, start, end, c.max_depth);
+        return;
+    }
 
-            if c.verbose {
-                println!("{} {}", article, depth);
+    // Only breadth-first search discovers articles in an order it can
+    // carry on from
+    let bfs_only = c
+        .strategy
+        .iter()
+        .all(|s| s.strategy == heuristic::Strategy::Bfs);
+    if (c.save_visited.is_some() || c.seed_visited.is_some()) && !bfs_only {
+        eprintln!("--save-visited and --seed-visited need --strategy bfs");
+        return;
+    }
+    // The paths of a backward search run the other way round its parents
+    let backward = c
+        .strategy
+        .iter()
+        .any(|s| s.strategy == heuristic::Strategy::Backlinks);
+    if (c.all || c.all_shortest) && backward {
+        eprintln!("--all and --all-shortest don't work with --strategy backlinks");
+        return;
+    }
+    // A backward search to a category would start from all its articles
+    if c.end_category.is_some() && (backward || c.race.contains(&heuristic::Strategy::Backlinks)) {
+        eprintln!("--end-category doesn't work with the backlinks strategy");
+        return;
+    }
+    if !c.race.is_empty() && c.race.len() != 2 {
+        eprintln!("--race takes two strategies, e.g. bfs,backlinks");
+        return;
+    }
+    let seed = match &c.seed_visited {
+        Some(path) => match visited::load(path) {
+            Ok(seed) if seed.articles[1] == *start => Some(seed),
+            Ok(seed) => {
+                eprintln!(
+                    "{} was explored from {}, not {}, searching afresh",
+                    path.display(),
+                    seed.articles[1],
+                    start
+                );
+                None
             }
+            Err(err) => {
+                eprintln!("{}: {}", path.display(), err);
+                return;
+            }
+        },
+        None => None,
+    };
+    let opts = &search::Options {
+        seed: seed.as_ref(),
+        ..*opts
+    };
 
-            // Build request
-            let url = format!("https://en.wikipedia.org/wiki/{}", article);
+    let start_time = Instant::now();
+    let report = |outcome, path: Option<&path::Path>, matched: &str| {
+        print_result(c, start, end, outcome, path, matched, start_time);
+    };
+
+    let requests = wiki.timings().requests;
+    // Into the history, with the first path found, whatever the outcome
+    let remember = |path: Option<path::Path>| {
+        if !opts.history {
+            return;
+        }
+        let strategy = match c.race[..] {
+            [a, b] => format!("race {},{}", a.name(), b.name()),
+            _ => {
+                let stages: Vec<String> = c.strategy.iter().map(|s| s.to_string()).collect();
+                stages.join(",")
+            }
+        };
+        history::record(&history::Search {
+            project: c.project,
+            start: start.clone(),
+            end: end.clone(),
+            strategy,
+            path,
+            requests: wiki.timings().requests - requests,
+            duration: start_time.elapsed(),
+        });
+    };
+
+    // Trivial cases that need no crawl
+    if start == end {
+        let path = path::Path::new(vec![start.clone()]);
+        report(Outcome::Same, Some(&path), end);
+        remember(Some(path));
+        return;
+    }
+    match wiki.canonical(&[start, end]) {
+        Ok(titles) if titles[0] == titles[1] => {
+            let path = path::Path::new(vec![start.clone(), end.clone()]);
+            report(Outcome::Redirect, Some(&path), &titles[1]);
+            remember(Some(path));
+            return;
+        }
+        Ok(_) => {}
+        Err(err) => eprintln!("{}", err),
+    }
 
-            let client = rw::blocking::Client::new();
-            let request = client.get(&url);
+    let target = match (&c.end_category, c.fuzzy) {
+        (Some(_), _) => search::Target::category(wiki, end),
+        (None, true) => search::Target::fuzzy(wiki, end),
+        (None, false) => Ok(search::Target::new(end)),
+    };
+    let target = match target {
+        Ok(target) => target,
+        Err(err) => {
+            eprintln!("{}", err);
+            return;
+        }
+    };
+
+    // A direct link needs no crawl either, unless the search would only
+    // follow some of the start's links
+    if c.end_category.is_none() && !c.lead_only && opts.plugin.is_none() && opts.max_links.is_none()
+    {
+        match wiki.links_to(start, target.title()) {
+            Ok(true) => {
+                let path = path::Path::new(vec![start.clone(), target.title().to_string()]);
+                report(Outcome::Direct, Some(&path), target.title());
+                remember(Some(path));
+                return;
+            }
+            Ok(false) => {}
+            Err(err) => eprintln!("{}", err),
+        }
+    }
 
-            // Rate-limit
-            let elapsed = prev_req.elapsed();
-            if elapsed < req_wait {
-                thread::sleep(req_wait - elapsed);
+    let outcome = |path: &path::Path| {
+        if path.links() == 1 {
+            Outcome::Direct
+        } else {
+            Outcome::Search
+        }
+    };
+    let mut found = None;
+    let on_path = |path: &path::Path| {
+        if found.is_none() {
+            found = Some(path.clone());
+        }
+        if !c.all_shortest {
+            report(outcome(path), Some(path), target.title());
+        }
+    };
+    let explored = match c.race[..] {
+        [a, b] => search::race(wiki, events, opts, [a, b], start, &target, on_path)
+            .map(|(_, explored)| explored),
+        _ => search::chain(wiki, events, opts, &c.strategy, start, &target, on_path),
+    };
+    let explored = match explored {
+        Ok(explored) => explored,
+        Err(err) => {
+            eprintln!("{}", err);
+            return;
+        }
+    };
+
+    if c.all_shortest && explored.found {
+        let mut count = 0u128;
+        for &idx in &explored.ends {
+            let (n, paths) = explored.shortest_paths(idx, c.max_paths);
+            for path in paths
+                .iter()
+                .take(c.max_paths.saturating_sub(count as usize))
+            {
+                report(outcome(path), Some(path), &explored.articles[idx]);
             }
-            prev_req = Instant::now();
-
-            // Send request
-            let res = match request.send() {
-                Ok(res) => res,
-                Err(err) => {
-                    eprintln!("{}", err);
-                    continue;
-                }
-            };
+            count = count.saturating_add(n);
+        }
+        print_count(c, start, end, count);
+    }
 
-            let body = match res.text() {
-                Ok(body) => body,
-                Err(err) => {
-                    eprintln!("{}", err);
-                    continue;
-                }
-            };
+    if !explored.found {
+        report(Outcome::NotFound, None, target.title());
+    }
+    remember(found);
 
-            let document = sc::Html::parse_document(&body);
-            let selector = sc::Selector::parse("a[href]").unwrap();
-
-            for element in document.select(&selector) {
-                if let Some(href) = element.value().attr("href") {
-                    if let Some(mut name) = href.strip_prefix("/wiki/") {
-                        // Remove #fragments
-                        if let Some(idx) = name.find('#') {
-                            name = &name[..idx];
-                        }
-                        // Exclude "Main_Page" or Special: / Talk: etc
-                        if name != "Main_Page" && !name.contains(':') {
-                            let new_article = name.to_string();
-
-                            if !articles.contains(&new_article) {
-                                articles.push(new_article);
-                                article_parent.insert(articles.len() - 1, curr_idx);
-
-                                next_level_len += 1;
-
-                                if name == c.end {
-                                    let elapsed = start_time.elapsed();
-
-                                    let mut path = Vec::new();
-
-                                    let mut current = articles.len() - 1;
-                                    while current != 0 {
-                                        path.push(&articles[current]);
-                                        current = article_parent[&current];
-                                    }
-
-                                    path.reverse();
-
-                                    println!("Path: {:?}", path);
-                                    println!("Length: {}", path.len());
-
-                                    let elapsed_sdur =
-                                        jiff::SignedDuration::from_secs_f64(elapsed.as_secs_f64());
-                                    println!("Took {elapsed_sdur:#}");
-
-                                    if !c.all {
-                                        return;
-                                    }
-                                }
-                            }
-                        }
-                    }
-                }
-            }
+    if c.timings && !explored.levels.is_empty() {
+        print_levels(&explored);
+    }
+
+    if let Some(path) = &c.export_graph {
+        if let Err(err) = graph::export(path, &explored.articles, &explored.depth, &explored.edges)
+        {
+            eprintln!("{}", err);
+        }
+    }
+    if let Some(path) = &c.save_visited {
+        if let Err(err) = visited::save(path, &explored) {
+            eprintln!("{}: {}", path.display(), err);
+        }
+    }
+}
+
+/// Add a search to the history, which is only worth a warning if it fails
+/// Print what each depth of a breadth-first search cost to stderr, with the
+/// articles it discovered one link further
+fn print_levels(explored: &search::Explored) {
+    eprintln!("Depth  Fetched  Discovered  Requests  Time");
+    for level in &explored.levels {
+        let discovered = explored
+            .depth
+            .iter()
+            .skip(2)
+            .filter(|&&d| d == level.depth + 1)
+            .count();
+        let elapsed = jiff::SignedDuration::from_secs_f64(level.elapsed.as_secs_f64());
+        eprintln!(
+            "{:>5}  {:>7}  {:>10}  {:>8}  {elapsed:#}",
+            level.depth, level.fetched, discovered, level.requests
+        );
+    }
+}
+
+/// Print the number of shortest paths found with --all-shortest
+fn print_count(c: &Cli, start: &str, end: &str, count: u128) {
+    if c.json {
+        let result = json!({
+            "start": start,
+            "end": end,
+            "shortest_paths": count.min(u64::MAX.into()) as u64,
+        });
+        println!("{}", result);
+    } else if !c.quiet {
+        let shown = count.min(c.max_paths as u128);
+        println!("Shortest paths: {} ({} shown)", count, shown);
+    }
+}
+
+/// How the result of a search came about
+#[derive(Clone, Copy, Debug)]
+enum Outcome {
+    /// Start and end are the same article
+    Same,
+    /// Start and end redirect to the same article
+    Redirect,
+    /// Start links to end
+    Direct,
+    /// Found by searching
+    Search,
+    NotFound,
+}
+
+impl Outcome {
+    fn as_str(self) -> &'static str {
+        match self {
+            Outcome::Same => "same",
+            Outcome::Redirect => "redirect",
+            Outcome::Direct => "direct",
+            Outcome::Search => "search",
+            Outcome::NotFound => "not_found",
         }
     }
 }
+
+/// Print a found path, or the lack of one, in the format picked on the
+/// command line. `matched` is the title of the article the path ends in.
+fn print_result(
+    c: &Cli,
+    start: &str,
+    end: &str,
+    outcome: Outcome,
+    path: Option<&path::Path>,
+    matched: &str,
+    start_time: Instant,
+) {
+    let elapsed = start_time.elapsed();
+
+    if c.json {
+        let result = json!({
+            "start": start,
+            "end": end,
+            "outcome": outcome.as_str(),
+            "path": path.map(|p| p.titles()),
+            "urls": path.map(|p| p.urls(c.project)),
+            "length": path.map(|p| p.len()),
+            "matched": path.map(|_| matched),
+            "elapsed_ms": elapsed.as_millis() as u64,
+        });
+        println!("{}", result);
+        return;
+    }
+
+    let Some(path) = path else {
+        if !c.quiet {
+            println!("No path found from {} to {}", start, end);
+        }
+        return;
+    };
+
+    if c.quiet {
+        println!("{}", path.join(&c.separator));
+        return;
+    }
+
+    match outcome {
+        Outcome::Same => println!("Start and end are the same article"),
+        Outcome::Redirect => println!("{} and {} are the same article ({})", start, end, matched),
+        Outcome::Direct => println!("{} links directly to {}", start, end),
+        Outcome::Search | Outcome::NotFound => {}
+    }
+    println!("Path: {:?}", path);
+    println!("Length: {}", path.len());
+    if c.fuzzy {
+        println!("Matched: {}", matched);
+    }
+
+    let elapsed_sdur = jiff::SignedDuration::from_secs_f64(elapsed.as_secs_f64());
+    println!("Took {elapsed_sdur:#}");
+}
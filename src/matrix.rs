@@ -0,0 +1,138 @@
+use std::{
+    fs::{self, File},
+    io::{self, Write},
+    path::Path,
+    time::Instant,
+};
+
+use crate::{events::Events, history, search, title, wiki::Wiki};
+
+/// Search between every ordered pair of the articles listed in `file` and
+/// write the distances, in links, as a CSV matrix to `output` or stdout.
+/// Rows are starts and columns ends; cells are empty where no path was
+/// found within the maximum depth.
+///
+/// The searches share the link cache, so each article is fetched once at
+/// most however many of them go through it.
+pub fn run(
+    wiki: &mut Wiki,
+    events: &Events,
+    opts: &search::Options,
+    file: &Path,
+    output: Option<&Path>,
+) {
+    if let Err(err) = matrix(wiki, events, opts, file, output) {
+        eprintln!("{}", err);
+    }
+}
+
+fn matrix(
+    wiki: &mut Wiki,
+    events: &Events,
+    opts: &search::Options,
+    file: &Path,
+    output: Option<&Path>,
+) -> Result<(), String> {
+    let args = read_titles(file)?;
+
+    wiki.enable_cache();
+    let wiki = &*wiki;
+
+    let titles = args
+        .iter()
+        .map(|arg| arg.resolve(wiki))
+        .collect::<Result<Vec<String>, _>>()
+        .map_err(|err| err.to_string())?;
+
+    let mut out: Box<dyn Write> = match output {
+        Some(path) => {
+            Box::new(File::create(path).map_err(|err| format!("{}: {}", path.display(), err))?)
+        }
+        None => Box::new(io::stdout()),
+    };
+    let write_err = |err: io::Error| err.to_string();
+
+    let opts = search::Options {
+        all: false,
+        all_shortest: false,
+        record_edges: false,
+        seed: None,
+        ..*opts
+    };
+
+    let header: Vec<String> = titles.iter().map(|t| field(t)).collect();
+    writeln!(out, ",{}", header.join(",")).map_err(write_err)?;
+
+    for start in &titles {
+        let mut row = vec![field(start)];
+        for end in &titles {
+            if start == end {
+                row.push("0".to_string());
+                continue;
+            }
+
+            let start_time = Instant::now();
+            let mut found = None;
+            let explored = search::bfs(
+                wiki,
+                events,
+                &opts,
+                start,
+                Some(&search::Target::new(end)),
+                |path| found = Some(path.clone()),
+            );
+            let distance = found.as_ref().map(|path| path.links());
+            row.push(distance.map_or(String::new(), |d| d.to_string()));
+            if opts.history {
+                history::record(&history::Search::bfs(
+                    wiki.project(),
+                    start,
+                    end,
+                    &explored,
+                    found,
+                    start_time.elapsed(),
+                ));
+            }
+        }
+
+        // Rows are written as they are done, so a long run shows progress
+        writeln!(out, "{}", row.join(",")).map_err(write_err)?;
+        out.flush().map_err(write_err)?;
+    }
+
+    Ok(())
+}
+
+/// Quote a CSV field if needed
+fn field(value: &str) -> String {
+    if value.contains([',', '"', '\n']) {
+        format!("\"{}\"", value.replace('"', "\"\""))
+    } else {
+        value.to_string()
+    }
+}
+
+/// Read one article per line, skipping blank lines and `#` comments
+fn read_titles(file: &Path) -> Result<Vec<title::Arg>, String> {
+    let content = fs::read_to_string(file).map_err(|err| format!("{}: {}", file.display(), err))?;
+
+    let mut titles = Vec::new();
+    for (n, line) in content.lines().enumerate() {
+        let line = line.trim();
+        if line.is_empty() || line.starts_with('#') {
+            continue;
+        }
+
+        let arg = title::parse_arg(line)
+            .map_err(|err| format!("{}:{}: {}", file.display(), n + 1, err))?;
+        titles.push(arg);
+    }
+    if titles.len() < 2 {
+        return Err(format!(
+            "{}: expected at least two articles",
+            file.display()
+        ));
+    }
+
+    Ok(titles)
+}
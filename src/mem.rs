@@ -0,0 +1,14 @@
+/// Resident set size of this process in bytes, where the platform exposes it
+#[cfg(target_os = "linux")]
+pub fn rss() -> Option<u64> {
+    let status = std::fs::read_to_string("/proc/self/status").ok()?;
+    let line = status.lines().find(|l| l.starts_with("VmRSS:"))?;
+    let kb: u64 = line.split_whitespace().nth(1)?.parse().ok()?;
+
+    Some(kb * 1024)
+}
+
+#[cfg(not(target_os = "linux"))]
+pub fn rss() -> Option<u64> {
+    None
+}
@@ -0,0 +1,62 @@
+use std::{fmt, ops::Deref};
+
+use serde_json::{json, Value};
+
+use crate::project::Project;
+
+/// The articles from the start of a search to an article it reached, in
+/// URL form. Derefs to the titles, and prints like them.
+#[derive(Clone, PartialEq, Eq)]
+pub struct Path {
+    titles: Vec<String>,
+}
+
+impl Path {
+    pub fn new(titles: Vec<String>) -> Self {
+        Path { titles }
+    }
+
+    pub fn titles(&self) -> &[String] {
+        &self.titles
+    }
+
+    /// Number of links to follow, one less than the number of articles
+    pub fn links(&self) -> usize {
+        self.titles.len().saturating_sub(1)
+    }
+
+    /// Page URLs of the articles on `project`
+    pub fn urls(&self, project: Project) -> Vec<String> {
+        self.titles.iter().map(|t| project.page_url(t)).collect()
+    }
+
+    /// Links to follow, each from an article to the next
+    pub fn edges(&self) -> impl Iterator<Item = (&str, &str)> {
+        self.titles
+            .windows(2)
+            .map(|pair| (pair[0].as_str(), pair[1].as_str()))
+    }
+
+    /// The path as a JSON object with its titles, URLs and length
+    pub fn to_json(&self, project: Project) -> Value {
+        json!({
+            "path": self.titles,
+            "urls": self.urls(project),
+            "length": self.titles.len(),
+        })
+    }
+}
+
+impl Deref for Path {
+    type Target = [String];
+
+    fn deref(&self) -> &[String] {
+        &self.titles
+    }
+}
+
+impl fmt::Debug for Path {
+    fn fmt(&self, f: &mut fmt::Formatter) -> fmt::Result {
+        self.titles.fmt(f)
+    }
+}
@@ -0,0 +1,69 @@
+use std::{
+    io,
+    sync::{
+        atomic::{AtomicBool, Ordering},
+        Arc,
+    },
+    thread,
+    time::Duration,
+};
+
+/// How often a paused search looks whether it may go on
+const POLL: Duration = Duration::from_millis(200);
+
+/// Pausing a running search from outside to see how far it has got: SIGUSR1
+/// pauses it before it fetches another article and prints what it has
+/// explored, and SIGUSR1 again lets it carry on.
+pub struct Pause {
+    paused: Arc<AtomicBool>,
+}
+
+impl Pause {
+    /// Start listening for SIGUSR1
+    #[cfg(unix)]
+    pub fn listen() -> io::Result<Self> {
+        use signal_hook::{consts::SIGUSR1, iterator::Signals};
+
+        let paused = Arc::new(AtomicBool::new(false));
+        let mut signals = Signals::new([SIGUSR1])?;
+        let toggle = paused.clone();
+        thread::spawn(move || {
+            for _ in signals.forever() {
+                toggle.fetch_xor(true, Ordering::Relaxed);
+            }
+        });
+
+        Ok(Pause { paused })
+    }
+
+    /// There are no signals to pause with on this platform
+    #[cfg(not(unix))]
+    pub fn listen() -> io::Result<Self> {
+        Ok(Pause {
+            paused: Arc::new(AtomicBool::new(false)),
+        })
+    }
+
+    pub fn paused(&self) -> bool {
+        self.paused.load(Ordering::Relaxed)
+    }
+
+    /// Wait until the search may go on, without telling
+    pub fn hold(&self) {
+        while self.paused() {
+            thread::sleep(POLL);
+        }
+    }
+
+    /// If the search is to pause, call `report` and wait until it may go on
+    pub fn checkpoint(&self, report: impl FnOnce()) {
+        if !self.paused() {
+            return;
+        }
+
+        report();
+        eprintln!("Paused, resume with: kill -USR1 {}", std::process::id());
+        self.hold();
+        eprintln!("Resuming");
+    }
+}
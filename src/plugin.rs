@@ -0,0 +1,159 @@
+use std::{
+    io::{BufRead, BufReader, Write},
+    process::{ChildStdin, ChildStdout, Command, Stdio},
+    sync::Mutex,
+};
+
+use serde_json::{json, Value};
+
+use crate::{title, wiki::Error};
+
+/// Version of the protocol, sent in the first message
+const PROTOCOL_VERSION: u32 = 1;
+
+/// A program deciding which links searches follow and how promising
+/// articles are, talked to over its stdin and stdout.
+///
+/// Every message is a JSON object on a line of its own, answered by one
+/// line. Titles are those of article URLs, percent-decoded.
+///
+/// - `{"type": "hello", "version": 1}` is sent first; the answer lists the
+///   hooks the plugin implements, e.g. `{"hooks": ["filter", "score"]}`.
+/// - `{"type": "filter", "article": ..., "links": [...]}` asks which links of
+///   an article to follow, answered by `{"keep": [true, false, ...]}`.
+/// - `{"type": "score", "end": ..., "articles": [...]}` asks how promising
+///   articles are, higher being better, answered by `{"scores": [...]}`.
+///
+/// Answering `{"error": "..."}` fails the request.
+pub struct Plugin {
+    hooks: Vec<String>,
+    io: Mutex<(ChildStdin, BufReader<ChildStdout>)>,
+}
+
+impl Plugin {
+    /// Run `command`, a program and its arguments separated by spaces
+    pub fn start(command: &str) -> Result<Self, Error> {
+        let mut words = command.split_whitespace();
+        let Some(program) = words.next() else {
+            return Err("the plugin command is empty".into());
+        };
+
+        // The plugin sees the end of its input once we exit
+        let mut child = Command::new(program)
+            .args(words)
+            .stdin(Stdio::piped())
+            .stdout(Stdio::piped())
+            .spawn()
+            .map_err(|err| format!("{}: {}", program, err))?;
+        let (Some(stdin), Some(stdout)) = (child.stdin.take(), child.stdout.take()) else {
+            return Err(format!("{}: no pipe to the plugin", program).into());
+        };
+
+        let mut plugin = Plugin {
+            hooks: Vec::new(),
+            io: Mutex::new((stdin, BufReader::new(stdout))),
+        };
+        let res = plugin.call(json!({ "type": "hello", "version": PROTOCOL_VERSION }))?;
+        plugin.hooks = res["hooks"]
+            .as_array()
+            .into_iter()
+            .flatten()
+            .filter_map(|hook| hook.as_str())
+            .map(str::to_string)
+            .collect();
+
+        Ok(plugin)
+    }
+
+    /// Whether the plugin implements `hook`
+    pub fn has(&self, hook: &str) -> bool {
+        self.hooks.iter().any(|h| h == hook)
+    }
+
+    /// The links of `article` the plugin keeps, all of them if it doesn't
+    /// filter
+    pub fn filter(&self, article: &str, links: Vec<String>) -> Result<Vec<String>, Error> {
+        if !self.has("filter") || links.is_empty() {
+            return Ok(links);
+        }
+
+        let decoded: Vec<String> = links.iter().map(|l| title::decode(l)).collect();
+        let res = self.call(json!({
+            "type": "filter",
+            "article": title::decode(article),
+            "links": decoded,
+        }))?;
+
+        let keep: Vec<bool> = res["keep"]
+            .as_array()
+            .into_iter()
+            .flatten()
+            .map(|k| k.as_bool().unwrap_or(false))
+            .collect();
+        if keep.len() != links.len() {
+            return Err(format!(
+                "plugin: expected {} filter results, got {}",
+                links.len(),
+                keep.len()
+            )
+            .into());
+        }
+
+        Ok(links
+            .into_iter()
+            .zip(keep)
+            .filter_map(|(link, keep)| keep.then_some(link))
+            .collect())
+    }
+
+    /// Scores of `names` on the way to `end`, in the same order
+    pub fn scores(&self, end: &str, names: &[String]) -> Result<Vec<f64>, Error> {
+        if names.is_empty() {
+            return Ok(Vec::new());
+        }
+
+        let decoded: Vec<String> = names.iter().map(|n| title::decode(n)).collect();
+        let res = self.call(json!({
+            "type": "score",
+            "end": title::decode(end),
+            "articles": decoded,
+        }))?;
+
+        let scores: Vec<f64> = res["scores"]
+            .as_array()
+            .into_iter()
+            .flatten()
+            .map(|s| s.as_f64().unwrap_or(0.0))
+            .collect();
+        if scores.len() != names.len() {
+            return Err(format!(
+                "plugin: expected {} scores, got {}",
+                names.len(),
+                scores.len()
+            )
+            .into());
+        }
+
+        Ok(scores)
+    }
+
+    /// Send `request` and wait for the answer
+    fn call(&self, request: Value) -> Result<Value, Error> {
+        let mut io = self.io.lock().unwrap();
+        let (stdin, stdout) = &mut *io;
+
+        writeln!(stdin, "{}", request).map_err(|err| format!("plugin: {}", err))?;
+        stdin.flush().map_err(|err| format!("plugin: {}", err))?;
+
+        let mut line = String::new();
+        if stdout.read_line(&mut line)? == 0 {
+            return Err("the plugin exited".into());
+        }
+        let res: Value = serde_json::from_str(&line).map_err(|err| format!("plugin: {}", err))?;
+        if let Some(err) = res["error"].as_str() {
+            return Err(format!("plugin: {}", err).into());
+        }
+
+        Ok(res)
+    }
+}
@@ -0,0 +1,60 @@
+use std::path::Path;
+
+use crate::wiki::Error;
+
+/// Samples per second taken of where the CPU time goes
+#[cfg(all(feature = "pprof", unix))]
+const FREQUENCY: i32 = 1000;
+
+/// Sampling of the whole run, written out as a flamegraph if the file ends
+/// in .svg and as a pprof profile otherwise, for `go tool pprof` or
+/// speedscope. It needs the `pprof` feature.
+pub struct CpuProfile {
+    #[cfg(all(feature = "pprof", unix))]
+    path: std::path::PathBuf,
+    #[cfg(all(feature = "pprof", unix))]
+    guard: pprof::ProfilerGuard<'static>,
+}
+
+impl CpuProfile {
+    #[cfg(all(feature = "pprof", unix))]
+    pub fn start(path: &Path) -> Result<Self, Error> {
+        let guard = pprof::ProfilerGuardBuilder::default()
+            .frequency(FREQUENCY)
+            // Unwinding through these from a signal handler can deadlock
+            .blocklist(&["libc", "libgcc", "pthread", "vdso"])
+            .build()?;
+
+        Ok(CpuProfile {
+            path: path.to_path_buf(),
+            guard,
+        })
+    }
+
+    #[cfg(not(all(feature = "pprof", unix)))]
+    pub fn start(_path: &Path) -> Result<Self, Error> {
+        Err("CPU profiles need wiki-path built with --features pprof, on Unix".into())
+    }
+
+    #[cfg(all(feature = "pprof", unix))]
+    pub fn finish(self) -> Result<(), Error> {
+        use pprof::protos::Message;
+
+        let report = self.guard.report().build()?;
+        if self.path.extension().is_some_and(|ext| ext == "svg") {
+            report.flamegraph(std::fs::File::create(&self.path)?)?;
+        } else {
+            let mut encoded = Vec::new();
+            report.pprof()?.encode(&mut encoded)?;
+            std::fs::write(&self.path, encoded)?;
+        }
+
+        eprintln!("CPU profile written to {}", self.path.display());
+        Ok(())
+    }
+
+    #[cfg(not(all(feature = "pprof", unix)))]
+    pub fn finish(self) -> Result<(), Error> {
+        Ok(())
+    }
+}
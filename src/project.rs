@@ -0,0 +1,176 @@
+use crate::title;
+
+/// Namespaces every MediaWiki site has, whose pages aren't articles
+const COMMON_NAMESPACES: &[&str] = &[
+    "Talk",
+    "User",
+    "Project",
+    "File",
+    "Image",
+    "MediaWiki",
+    "Template",
+    "Help",
+    "Category",
+    "Special",
+    "Media",
+    "Module",
+    "TimedText",
+    "Gadget",
+    "Gadget_definition",
+];
+
+/// A Wikimedia project that can be searched
+#[derive(Clone, Copy, Debug, PartialEq, Eq, clap::ValueEnum)]
+pub enum Project {
+    /// English Wikipedia
+    Wikipedia,
+    /// Simple English Wikipedia
+    Simple,
+    /// English Wiktionary
+    Wiktionary,
+    /// English Wikivoyage
+    Wikivoyage,
+    /// English Wikiquote
+    Wikiquote,
+}
+
+impl Project {
+    pub fn host(self) -> &'static str {
+        match self {
+            Project::Wikipedia => "en.wikipedia.org",
+            Project::Simple => "simple.wikipedia.org",
+            Project::Wiktionary => "en.wiktionary.org",
+            Project::Wikivoyage => "en.wikivoyage.org",
+            Project::Wikiquote => "en.wikiquote.org",
+        }
+    }
+
+    /// Whether `host` is the project's desktop or mobile host
+    pub fn is_host(self, host: &str) -> bool {
+        let mobile = self.host().replacen('.', ".m.", 1);
+        host.eq_ignore_ascii_case(self.host()) || host.eq_ignore_ascii_case(&mobile)
+    }
+
+    /// Wikidata's ID of the project, under which items link to its articles
+    pub fn wikidata_site(self) -> Option<&'static str> {
+        match self {
+            Project::Wikipedia => Some("enwiki"),
+            Project::Simple => Some("simplewiki"),
+            Project::Wikivoyage => Some("enwikivoyage"),
+            Project::Wikiquote => Some("enwikiquote"),
+            // Wiktionary entries aren't linked to items
+            Project::Wiktionary => None,
+        }
+    }
+
+    /// URL of the page `title`
+    pub fn page_url(self, title: &str) -> String {
+        format!("https://{}/wiki/{}", self.host(), title)
+    }
+
+    /// URL of the HTML of the page `title` on the API Portal's REST API
+    pub fn portal_page_url(self, title: &str) -> String {
+        let (project, language) = match self {
+            Project::Wikipedia => ("wikipedia", "en"),
+            Project::Simple => ("wikipedia", "simple"),
+            Project::Wiktionary => ("wiktionary", "en"),
+            Project::Wikivoyage => ("wikivoyage", "en"),
+            Project::Wikiquote => ("wikiquote", "en"),
+        };
+        format!(
+            "https://api.wikimedia.org/core/v1/{}/{}/page/{}/html",
+            project, language, title
+        )
+    }
+
+    /// URL of the Action API
+    pub fn api_url(self) -> String {
+        format!("https://{}/w/api.php", self.host())
+    }
+
+    /// Name of the page `href` links to on the project, if it does: a
+    /// `/wiki/` path, or an absolute or protocol-relative URL on the
+    /// project's desktop or mobile host, without query string or fragment
+    pub fn link_name(self, href: &str) -> Option<&str> {
+        let href = href.trim();
+
+        let rest = ["https://", "http://", "//"]
+            .iter()
+            .find_map(|scheme| href.strip_prefix(scheme));
+        let path = match rest {
+            Some(rest) => {
+                let (host, path) = rest.split_at(rest.find('/')?);
+                if !self.is_host(host) {
+                    return None;
+                }
+                path
+            }
+            None => href,
+        };
+
+        let name = path.strip_prefix("/wiki/")?;
+        name.split(['?', '#']).next()
+    }
+
+    /// Whether titles get their first letter capitalized, as on every
+    /// project but Wiktionary
+    pub fn capitalized(self) -> bool {
+        self != Project::Wiktionary
+    }
+
+    /// Namespaces specific to the project, on top of the common ones
+    fn namespaces(self) -> &'static [&'static str] {
+        match self {
+            Project::Wikipedia | Project::Simple => &["Wikipedia", "WP", "Portal", "Draft"],
+            Project::Wiktionary => &[
+                "Wiktionary",
+                "WT",
+                "Appendix",
+                "Concordance",
+                "Index",
+                "Rhymes",
+                "Transwiki",
+                "Thesaurus",
+                "Citations",
+                "Sign_gloss",
+                "Reconstruction",
+            ],
+            Project::Wikivoyage => &["Wikivoyage", "WV"],
+            Project::Wikiquote => &["Wikiquote", "WQ"],
+        }
+    }
+
+    /// Whether `name`, taken from a link, is an article of the project and
+    /// not a page in another namespace or the main page
+    pub fn is_article(self, name: &str) -> bool {
+        if name.is_empty() || name == "Main_Page" {
+            return false;
+        }
+
+        match name.split_once(':') {
+            Some((prefix, _)) => !self.is_namespace(&title::decode(prefix)),
+            None => true,
+        }
+    }
+
+    fn is_namespace(self, prefix: &str) -> bool {
+        let prefix = prefix.replace(' ', "_");
+        prefix.to_ascii_lowercase().ends_with("_talk")
+            || COMMON_NAMESPACES
+                .iter()
+                .chain(self.namespaces())
+                .any(|ns| ns.eq_ignore_ascii_case(&prefix))
+    }
+}
+
+/// Whether `host` belongs to one of the supported projects
+pub fn is_project_host(host: &str) -> bool {
+    [
+        ".wikipedia.org",
+        ".wiktionary.org",
+        ".wikivoyage.org",
+        ".wikiquote.org",
+    ]
+    .iter()
+    .any(|domain| host.ends_with(domain))
+}
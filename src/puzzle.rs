@@ -0,0 +1,62 @@
+use std::hash::{BuildHasher, RandomState};
+
+use crate::{events::Events, search, title, wiki::Wiki};
+
+/// Find and print a start/end pair whose shortest path is exactly `distance`
+/// links long, starting from `start` or a random article.
+pub fn run(
+    wiki: &Wiki,
+    events: &Events,
+    opts: &search::Options,
+    start: Option<&title::Arg>,
+    distance: u32,
+    solution: bool,
+) {
+    let start = match start.unwrap_or(&title::Arg::Random).resolve(wiki) {
+        Ok(start) => start,
+        Err(err) => {
+            eprintln!("{}", err);
+            return;
+        }
+    };
+
+    // Expanding every level before `distance` means an article first
+    // discovered at `distance` can't be reached in fewer links, as long as
+    // every link is followed
+    let opts = search::Options {
+        max_depth: distance - 1,
+        all: false,
+        record_edges: false,
+        max_links: None,
+        ..*opts
+    };
+    let explored = search::bfs(wiki, events, &opts, &start, None, |_| {});
+
+    let closer = explored.depth.iter().filter(|&&d| d < distance).count() - 1;
+    if explored.expanded < closer {
+        eprintln!(
+            "{} of {} articles closer than {} could not be fetched, the distance may be shorter",
+            closer - explored.expanded,
+            closer,
+            distance,
+        );
+    }
+
+    let candidates: Vec<usize> = (1..explored.articles.len())
+        .filter(|&idx| explored.depth[idx] == distance)
+        .collect();
+    if candidates.is_empty() {
+        eprintln!("No article is {} links away from {}", distance, start);
+        return;
+    }
+
+    let pick = RandomState::new().hash_one(&start) as usize % candidates.len();
+    let end = candidates[pick];
+
+    println!("Start: {}", start);
+    println!("End: {}", explored.articles[end]);
+    println!("Distance: {}", distance);
+    if solution {
+        println!("Path: {:?}", explored.path_to(end));
+    }
+}
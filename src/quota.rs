@@ -0,0 +1,138 @@
+use std::{
+    env,
+    fs::{self, OpenOptions},
+    io::{self, Read, Seek, SeekFrom, Write},
+    path::PathBuf,
+    thread,
+    time::{Duration, SystemTime, UNIX_EPOCH},
+};
+
+const WINDOW: Duration = Duration::from_secs(3600);
+
+/// Requests made in the last hour by every run of the tool on this machine,
+/// kept in a file so that running it again, or several times at once, can't
+/// go past the hourly limit together, nor send requests closer together than
+/// a single run would.
+///
+/// The file lists the Unix time in milliseconds of each request, one per
+/// line, and is locked while it is updated.
+pub struct Quota {
+    path: PathBuf,
+    per_hour: u32,
+    /// Shortest time between two requests of any runs
+    gap: Duration,
+}
+
+/// Why a request has to wait
+enum Wait {
+    /// Another run sent one too recently
+    Gap(Duration),
+    /// The hourly quota is used up
+    Quota(Duration),
+}
+
+impl Quota {
+    /// Quota of `account` ("anonymous" without a token), whose requests are
+    /// at least `gap` apart, or `None` if there is nowhere to keep it
+    pub fn open(account: &str, per_hour: u32, gap: Duration) -> Option<Self> {
+        let dir = cache_dir()?;
+        fs::create_dir_all(&dir).ok()?;
+
+        Some(Quota {
+            path: dir.join(format!("quota-{}", account)),
+            per_hour,
+            gap,
+        })
+    }
+
+    /// Record a request, first waiting for the oldest one to leave the window
+    /// if the quota is used up, and for the gap after the latest one to pass.
+    /// Returns the time waited.
+    pub fn acquire(&self) -> io::Result<Duration> {
+        let mut waited = Duration::ZERO;
+        let mut told = false;
+        loop {
+            let wait = match self.try_acquire()? {
+                None => return Ok(waited),
+                Some(Wait::Gap(wait)) => wait,
+                Some(Wait::Quota(wait)) => {
+                    if !told {
+                        let fmt = jiff::SignedDuration::from_secs_f64(wait.as_secs_f64());
+                        eprintln!("Hourly request quota used up, waiting {:#}", fmt);
+                        told = true;
+                    }
+                    wait
+                }
+            };
+            // Not holding the lock, so other runs can see the quota too
+            thread::sleep(wait);
+            waited += wait;
+        }
+    }
+
+    /// Record a request if the quota allows it, otherwise return how long to
+    /// wait before trying again
+    fn try_acquire(&self) -> io::Result<Option<Wait>> {
+        let mut file = OpenOptions::new()
+            .read(true)
+            .write(true)
+            .create(true)
+            .truncate(false)
+            .open(&self.path)?;
+        file.lock()?;
+
+        let mut content = String::new();
+        file.read_to_string(&mut content)?;
+
+        let now = millis(SystemTime::now());
+        let window_start = now.saturating_sub(WINDOW.as_millis() as u64);
+        let mut times: Vec<u64> = content
+            .lines()
+            .filter_map(|line| line.parse().ok())
+            .filter(|&t| t > window_start)
+            .collect();
+
+        if times.len() >= self.per_hour as usize {
+            let oldest = times.iter().min().copied().unwrap_or(now);
+            let wait = Duration::from_millis(oldest - window_start).max(Duration::from_millis(1));
+            return Ok(Some(Wait::Quota(wait)));
+        }
+        let next = times
+            .iter()
+            .max()
+            .map_or(0, |&t| t + self.gap.as_millis() as u64);
+        if next > now {
+            // At most the gap, should the clock have gone back
+            let wait = Duration::from_millis(next - now).min(self.gap);
+            return Ok(Some(Wait::Gap(wait)));
+        }
+        times.push(now);
+
+        let mut out = String::with_capacity(times.len() * 14);
+        for t in times {
+            out.push_str(&t.to_string());
+            out.push('\n');
+        }
+        file.set_len(0)?;
+        file.seek(SeekFrom::Start(0))?;
+        file.write_all(out.as_bytes())?;
+
+        Ok(None)
+    }
+}
+
+fn millis(time: SystemTime) -> u64 {
+    time.duration_since(UNIX_EPOCH)
+        .unwrap_or_default()
+        .as_millis() as u64
+}
+
+/// Per-user cache directory of the tool
+pub fn cache_dir() -> Option<PathBuf> {
+    let base = env::var_os("XDG_CACHE_HOME")
+        .map(PathBuf::from)
+        .or_else(|| env::var_os("LOCALAPPDATA").map(PathBuf::from))
+        .or_else(|| env::var_os("HOME").map(|home| PathBuf::from(home).join(".cache")))?;
+
+    Some(base.join("wiki-path"))
+}
@@ -0,0 +1,82 @@
+use std::io::{self, BufRead, Write};
+
+use crate::{events::Events, search, title, wiki::Wiki};
+
+/// Interactive Wiki-race assistant: read the article the player is on from
+/// stdin and suggest the next link to click towards `end`.
+///
+/// Links are cached for the whole game, so moving to one of the suggested
+/// articles costs little more than a lookup.
+pub fn run(wiki: &mut Wiki, events: &Events, opts: &search::Options, end: &title::Arg, hint: bool) {
+    wiki.enable_cache();
+
+    let end = match end.resolve(wiki) {
+        Ok(end) => end,
+        Err(err) => {
+            eprintln!("{}", err);
+            return;
+        }
+    };
+    let target = search::Target::new(&end);
+
+    let opts = search::Options {
+        all: false,
+        record_edges: false,
+        ..*opts
+    };
+
+    let stdin = io::stdin();
+    let mut lines = stdin.lock().lines();
+
+    loop {
+        print!("Current article: ");
+        io::stdout().flush().ok();
+
+        let line = match lines.next() {
+            Some(Ok(line)) => line,
+            Some(Err(err)) => {
+                eprintln!("{}", err);
+                return;
+            }
+            None => return,
+        };
+        if line.trim().is_empty() {
+            return;
+        }
+
+        let current = match title::parse_arg(&line).map(|arg| arg.resolve(wiki)) {
+            Ok(Ok(current)) => current,
+            Ok(Err(err)) => {
+                eprintln!("{}", err);
+                continue;
+            }
+            Err(err) => {
+                eprintln!("{}", err);
+                continue;
+            }
+        };
+
+        if current == end {
+            println!("You made it to {}!", end);
+            return;
+        }
+
+        let mut path = None;
+        search::bfs(wiki, events, &opts, &current, Some(&target), |p| {
+            path = Some(p.clone());
+        });
+
+        // The start isn't the end, so the path has a first link
+        let Some(path) = path else {
+            println!("No path to {} from {}", end, current);
+            continue;
+        };
+        let (_, next) = path.edges().next().unwrap();
+        if hint {
+            println!("Next click: {} ({} to go)", next, path.links())
+        } else {
+            println!("Next click: {}", next);
+            println!("Path: {:?}", path);
+        }
+    }
+}
@@ -0,0 +1,115 @@
+use std::{
+    io::{self, BufRead, Write},
+    time::Instant,
+};
+
+use crate::{events::Events, history, search, title, wiki::Wiki};
+
+const HELP: &str = "\
+find <start> <end>  search for a path, quoting titles with spaces
+help                show this message
+quit                leave";
+
+/// Read `find` queries from stdin until EOF or `quit`, keeping the link
+/// cache warm between them
+pub fn run(wiki: &mut Wiki, events: &Events, opts: &search::Options) {
+    wiki.enable_cache();
+
+    let opts = search::Options {
+        record_edges: false,
+        ..*opts
+    };
+
+    let stdin = io::stdin();
+    let mut lines = stdin.lock().lines();
+
+    loop {
+        print!("> ");
+        io::stdout().flush().ok();
+
+        let line = match lines.next() {
+            Some(Ok(line)) => line,
+            Some(Err(err)) => {
+                eprintln!("{}", err);
+                return;
+            }
+            None => return,
+        };
+
+        match split(&line)[..] {
+            [] => {}
+            ["quit" | "exit"] => return,
+            ["help"] => println!("{}", HELP),
+            ["find", start, end] => find(wiki, events, &opts, start, end),
+            _ => println!("Unknown command, try help"),
+        }
+    }
+}
+
+fn find(wiki: &Wiki, events: &Events, opts: &search::Options, start: &str, end: &str) {
+    let arg = |a| {
+        title::parse_arg(a)
+            .map_err(|err| err.into())
+            .and_then(|a| a.resolve(wiki))
+    };
+    let (start, end) = match (arg(start), arg(end)) {
+        (Ok(start), Ok(end)) => (start, end),
+        (Err(err), _) | (_, Err(err)) => {
+            eprintln!("{}", err);
+            return;
+        }
+    };
+
+    let start_time = Instant::now();
+    let requests = wiki.timings().requests;
+
+    let mut found = None;
+    let explored = search::bfs(
+        wiki,
+        events,
+        opts,
+        &start,
+        Some(&search::Target::new(&end)),
+        |path| {
+            println!("Path: {:?}", path);
+            println!("Length: {}", path.len());
+            found.get_or_insert_with(|| path.clone());
+        },
+    );
+    if opts.history {
+        history::record(&history::Search::bfs(
+            wiki.project(),
+            &start,
+            &end,
+            &explored,
+            found,
+            start_time.elapsed(),
+        ));
+    }
+    if !explored.found {
+        println!("No path found from {} to {}", start, end);
+    }
+
+    let elapsed = jiff::SignedDuration::from_secs_f64(start_time.elapsed().as_secs_f64());
+    println!(
+        "Took {elapsed:#}, {} requests",
+        wiki.timings().requests - requests
+    );
+}
+
+/// Split a line on whitespace, keeping "quoted strings" together
+fn split(line: &str) -> Vec<&str> {
+    let mut words = Vec::new();
+    let mut rest = line.trim_start();
+
+    while !rest.is_empty() {
+        let (word, tail) = match rest.strip_prefix('"') {
+            Some(quoted) => quoted.split_once('"').unwrap_or((quoted, "")),
+            None => rest.split_once(char::is_whitespace).unwrap_or((rest, "")),
+        };
+        words.push(word);
+        rest = tail.trim_start();
+    }
+
+    words
+}
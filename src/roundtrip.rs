@@ -0,0 +1,79 @@
+use std::time::Instant;
+
+use crate::{events::Events, history, search, title, wiki::Wiki};
+
+/// Search from `a` to `b` and back. Links are directed, so the two paths
+/// often differ in length; the cache lets the second search reuse the
+/// articles the first one fetched.
+pub fn run(
+    wiki: &mut Wiki,
+    events: &Events,
+    opts: &search::Options,
+    a: &title::Arg,
+    b: &title::Arg,
+) {
+    wiki.enable_cache();
+    let wiki = &*wiki;
+
+    let (a, b) = match (a.resolve(wiki), b.resolve(wiki)) {
+        (Ok(a), Ok(b)) => (a, b),
+        (Err(err), _) | (_, Err(err)) => {
+            eprintln!("{}", err);
+            return;
+        }
+    };
+
+    let opts = search::Options {
+        all: false,
+        all_shortest: false,
+        record_edges: false,
+        ..*opts
+    };
+
+    let mut lengths = Vec::new();
+    for (start, end) in [(&a, &b), (&b, &a)] {
+        let start_time = Instant::now();
+        let mut found = None;
+        let explored = search::bfs(
+            wiki,
+            events,
+            &opts,
+            start,
+            Some(&search::Target::new(end)),
+            |path| {
+                found = Some(path.clone());
+            },
+        );
+        let elapsed = jiff::SignedDuration::from_secs_f64(start_time.elapsed().as_secs_f64());
+        if opts.history {
+            history::record(&history::Search::bfs(
+                wiki.project(),
+                start,
+                end,
+                &explored,
+                found.clone(),
+                start_time.elapsed(),
+            ));
+        }
+
+        match &found {
+            Some(path) => println!(
+                "{} -> {}: {:?}, length {}, took {elapsed:#}",
+                start,
+                end,
+                path,
+                path.len()
+            ),
+            None => println!("{} -> {}: no path, took {elapsed:#}", start, end),
+        }
+        lengths.push(found.map(|path| path.len()));
+    }
+
+    match (lengths[0], lengths[1]) {
+        (Some(there), Some(back)) if there == back => println!("Both ways are {} long", there),
+        (Some(there), Some(back)) => {
+            println!("Asymmetric: {} there, {} back", there, back)
+        }
+        _ => {}
+    }
+}
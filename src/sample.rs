@@ -0,0 +1,159 @@
+use std::collections::{HashMap, HashSet};
+
+use crate::{events::Events, search, title, wiki::Wiki};
+
+/// Number of hubs listed
+const MAX_LISTED: usize = 10;
+
+/// Levels beyond the sample the cost of a search is extrapolated to
+const EXTRAPOLATED: u32 = 2;
+
+/// Crawl every article less than `depth` links from `start`, at most
+/// `budget` of them, and report how many links articles have, how fast the
+/// neighborhood grows and which articles are hubs, so the cost of a deeper
+/// search can be predicted.
+pub fn run(
+    wiki: &mut Wiki,
+    events: &Events,
+    opts: &search::Options,
+    start: &title::Arg,
+    depth: u32,
+    budget: Option<usize>,
+) {
+    let start = match start.resolve(wiki) {
+        Ok(start) => start,
+        Err(err) => {
+            eprintln!("{}", err);
+            return;
+        }
+    };
+
+    // The links of every expanded article are read back from the cache
+    wiki.enable_cache();
+    let wiki = &*wiki;
+
+    let opts = search::Options {
+        max_depth: depth - 1,
+        all: false,
+        all_shortest: false,
+        record_edges: false,
+        budget,
+        seed: None,
+        ..*opts
+    };
+    let explored = search::bfs(wiki, events, &opts, &start, None, |_| {});
+
+    let mut discovered = vec![0usize; depth as usize + 1];
+    for &d in &explored.depth[1..] {
+        discovered[d as usize] += 1;
+    }
+
+    let mut expanded = vec![0usize; depth as usize];
+    let mut out_degrees = Vec::new();
+    let mut in_degrees: HashMap<&str, usize> = HashMap::new();
+    let cached: Vec<(usize, HashSet<String>)> = (1..explored.articles.len())
+        .filter_map(|idx| {
+            let links = wiki.cached_links(&explored.articles[idx])?;
+            Some((idx, links.into_iter().collect()))
+        })
+        .collect();
+    for (idx, links) in &cached {
+        expanded[explored.depth[*idx] as usize] += 1;
+        out_degrees.push((links.len(), explored.articles[*idx].as_str()));
+        for link in links {
+            *in_degrees.entry(link).or_default() += 1;
+        }
+    }
+
+    let stats = explored.stats();
+    println!(
+        "Fetched {} articles, discovered {}",
+        stats.expanded, stats.discovered
+    );
+
+    println!("Depth  Expanded  Discovered  Branching");
+    let mut branching = None;
+    for d in 0..=depth as usize {
+        let factor = expanded
+            .get(d)
+            .filter(|&&e| e > 0)
+            .map(|&e| discovered[d + 1] as f64 / e as f64);
+        println!(
+            "{:>5}  {:>8}  {:>10}  {:>9}",
+            d,
+            expanded.get(d).copied().unwrap_or(0),
+            discovered[d],
+            factor.map_or("-".to_string(), |f| format!("{:.1}", f))
+        );
+        branching = factor.or(branching);
+    }
+
+    if out_degrees.is_empty() {
+        println!("No article could be fetched");
+        return;
+    }
+    out_degrees.sort_unstable_by(|a, b| b.0.cmp(&a.0).then(a.1.cmp(b.1)));
+
+    let mut degrees: Vec<usize> = out_degrees.iter().map(|&(n, _)| n).collect();
+    degrees.sort_unstable();
+    let percentile = |p: usize| degrees[(degrees.len() - 1) * p / 100];
+    println!(
+        "Out-degree: min {}, median {}, mean {:.1}, 90th percentile {}, max {}",
+        degrees[0],
+        percentile(50),
+        degrees.iter().sum::<usize>() as f64 / degrees.len() as f64,
+        percentile(90),
+        degrees[degrees.len() - 1]
+    );
+    println!("Out-degree  Articles");
+    for (low, high) in [(0, 10), (10, 100), (100, 1000), (1000, usize::MAX)] {
+        let count = degrees.iter().filter(|&&n| n >= low && n < high).count();
+        let range = if high == usize::MAX {
+            format!("{}+", low)
+        } else {
+            format!("{}-{}", low, high - 1)
+        };
+        println!("{:>10}  {}", range, count);
+    }
+
+    let most_out: Vec<String> = out_degrees
+        .iter()
+        .take(MAX_LISTED)
+        .map(|(n, name)| format!("{} ({})", name, n))
+        .collect();
+    println!("Most links out: {}", most_out.join(", "));
+
+    // Linked to from many sampled articles, so likely on many paths
+    let mut most_in: Vec<(&str, usize)> = in_degrees.into_iter().collect();
+    most_in.sort_unstable_by(|a, b| b.1.cmp(&a.1).then(a.0.cmp(b.0)));
+    let most_in: Vec<String> = most_in
+        .iter()
+        .take(MAX_LISTED)
+        .map(|(name, n)| format!("{} ({})", name, n))
+        .collect();
+    println!("Most linked to: {}", most_in.join(", "));
+
+    // The search fetches articles closer than `depth` until the budget runs
+    // out
+    let closer = explored.depth[1..].iter().filter(|&&d| d < depth).count();
+    if budget.is_some_and(|budget| budget < closer) {
+        println!("Budget used up, the deepest levels are incomplete");
+    }
+
+    // Keep growing the last level by the last branching factor seen
+    let Some(branching) = branching else {
+        return;
+    };
+    let mut level = discovered[depth as usize] as f64;
+    let mut total: f64 = discovered[..depth as usize].iter().sum::<usize>() as f64;
+    for max_depth in depth..depth + EXTRAPOLATED {
+        total += level;
+        let secs = total * wiki.req_wait().as_secs_f64();
+        let time = jiff::SignedDuration::from_secs_f64(secs.round());
+        println!(
+            "A search expanding every article within {} links fetches ~{:.0}, taking ~{time:#}",
+            max_depth, total
+        );
+        level *= branching;
+    }
+}
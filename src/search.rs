@@ -0,0 +1,1619 @@
+use std::{
+    cmp::Ordering,
+    collections::{BinaryHeap, HashMap, HashSet, VecDeque},
+    hash::{BuildHasher, RandomState},
+    sync::{
+        atomic::{AtomicBool, Ordering as AtomicOrdering},
+        Condvar, Mutex,
+    },
+    thread,
+    time::{Duration, Instant},
+};
+
+use serde_json::json;
+
+use crate::{
+    embeddings::Embeddings,
+    events::Events,
+    exclude::Exclude,
+    heuristic::{Heuristic, Stage, Strategy},
+    mem,
+    path::Path,
+    pause::Pause,
+    plugin::Plugin,
+    spill::Spill,
+    sqlite, title,
+    wiki::{self, Error, Missing, Wiki},
+};
+
+/// Fraction of the memory cap at which the search starts saving memory
+const MEMORY_HIGH_WATER: f64 = 0.9;
+/// Articles expanded between two spills once memory is being saved
+const SPILL_EVERY: usize = 10_000;
+
+/// Articles random walks fetch when not given a budget
+const WALK_BUDGET: usize = 200;
+/// Partial chains a paused search shows
+const PAUSE_CHAINS: usize = 5;
+/// Walks in a row fetching nothing new after which random walks give up,
+/// every way on being known
+const MAX_IDLE_WALKS: usize = 100;
+
+pub struct Options<'a> {
+    pub max_depth: u32,
+    /// Keep searching after the first path is found
+    pub all: bool,
+    /// Finish the level the first path is found at, recording every parent
+    /// of its articles, so all shortest paths can be listed
+    pub all_shortest: bool,
+    pub verbose: bool,
+    /// Record every link seen, not just the ones discovering new articles
+    pub record_edges: bool,
+    /// Stop after fetching the links of this many articles
+    pub budget: Option<usize>,
+    /// Resident memory in bytes to keep under. Near it a breadth-first
+    /// search spills the names of the articles it expanded to disk, prunes
+    /// part of its frontier and stops keeping the other parents of
+    /// articles; at it, it stops adding articles to the frontier.
+    pub max_memory: Option<u64>,
+    /// Only follow the first N links of each article, besides links to the end
+    pub max_links: Option<usize>,
+    /// Only search through these articles, the end excepted
+    pub scope: Option<&'a HashSet<String>>,
+    /// Don't search through articles in these categories, the end excepted
+    pub exclude: Option<&'a Exclude>,
+    /// Record discovered articles and links here during the search
+    pub db: Option<&'a sqlite::Db>,
+    /// Word vectors for the embeddings strategy
+    pub embeddings: Option<&'a Embeddings>,
+    /// Program deciding which links are followed
+    pub plugin: Option<&'a Plugin>,
+    /// Earlier breadth-first search from the same start to pick up from,
+    /// instead of fetching its articles again
+    pub seed: Option<&'a Explored>,
+    /// Stop before fetching another article once this is set
+    pub cancel: Option<&'a AtomicBool>,
+    /// Pause before fetching another article when asked to
+    pub pause: Option<&'a Pause>,
+    /// Record searches in the history, each pair of a batch, matrix or
+    /// round trip included
+    pub history: bool,
+}
+
+/// Summary of what a search fetched and found
+#[derive(Clone, Copy, Debug)]
+pub struct SearchStats {
+    /// Articles whose links were fetched
+    pub expanded: usize,
+    pub discovered: usize,
+    /// Depth of the farthest article discovered
+    pub max_depth: u32,
+}
+
+/// What a breadth-first search spent on the articles of one depth
+#[derive(Clone, Copy, Debug)]
+pub struct Level {
+    pub depth: u32,
+    /// Articles whose links were fetched, or failed to be
+    pub fetched: usize,
+    /// Requests made fetching them
+    pub requests: u32,
+    /// Time the search waited for their links
+    pub elapsed: Duration,
+}
+
+/// The article a search is looking for
+pub struct Target {
+    title: String,
+    /// Other names matching the target, in the form returned by `alias_key`
+    aliases: HashSet<String>,
+    /// Articles matching the target as members of the category `title`
+    members: HashSet<String>,
+}
+
+impl Target {
+    /// Match `title` exactly
+    pub fn new(title: &str) -> Self {
+        Target {
+            title: title.to_string(),
+            aliases: HashSet::new(),
+            members: HashSet::new(),
+        }
+    }
+
+    /// Match `title`, its canonical title and every redirect to it, ignoring
+    /// case
+    pub fn fuzzy(wiki: &Wiki, title: &str) -> Result<Self, Error> {
+        let (canonical, redirects) = wiki.redirects(title)?;
+
+        let mut aliases: HashSet<String> = redirects.iter().map(|r| alias_key(r)).collect();
+        aliases.insert(alias_key(title));
+        aliases.insert(alias_key(&canonical));
+
+        Ok(Target {
+            title: canonical,
+            aliases,
+            members: HashSet::new(),
+        })
+    }
+
+    /// Match any article in `category`, e.g. `Category:Birds`. Its members
+    /// are listed once, here.
+    pub fn category(wiki: &Wiki, category: &str) -> Result<Self, Error> {
+        let members = wiki.category_members(category)?;
+        if members.is_empty() {
+            return Err(format!("{} has no articles", title::decode(category)).into());
+        }
+
+        Ok(Target {
+            title: category.to_string(),
+            aliases: HashSet::new(),
+            members,
+        })
+    }
+
+    /// The canonical title, for fuzzy targets, the category for category ones
+    pub fn title(&self) -> &str {
+        &self.title
+    }
+
+    pub fn matches(&self, name: &str) -> bool {
+        name == self.title
+            || self.members.contains(name)
+            || (!self.aliases.is_empty() && self.aliases.contains(&alias_key(name)))
+    }
+}
+
+fn alias_key(name: &str) -> String {
+    title::underscored(&title::decode(name)).to_lowercase()
+}
+
+/// The part of the link graph explored by a search.
+///
+/// Articles are stored in discovery order; index 0 is a placeholder root
+/// whose only child is the start article.
+#[derive(Clone)]
+pub struct Explored {
+    pub articles: Vec<String>,
+    pub parent: Vec<usize>,
+    /// Parents other than `parent` one link closer to the start, when
+    /// searching with `all_shortest`
+    pub other_parents: HashMap<usize, Vec<usize>>,
+    pub depth: Vec<u32>,
+    pub edges: Vec<(usize, usize)>,
+    /// Number of articles whose links were fetched
+    pub expanded: usize,
+    pub found: bool,
+    /// Articles matching the end, in discovery order
+    pub ends: Vec<usize>,
+    /// Number of articles, in discovery order, done with by a breadth-first
+    /// search: their links were fetched, or failed to be
+    pub processed: usize,
+    /// Cost of each depth, for breadth-first searches
+    pub levels: Vec<Level>,
+}
+
+impl Explored {
+    fn new(start: &str) -> Self {
+        Explored {
+            articles: vec![String::new(), start.to_string()],
+            parent: vec![0, 0],
+            other_parents: HashMap::new(),
+            depth: vec![0, 0],
+            edges: Vec::new(),
+            expanded: 0,
+            found: false,
+            ends: Vec::new(),
+            processed: 0,
+            levels: Vec::new(),
+        }
+    }
+
+    /// Path from the start article to the article at `idx`
+    pub fn path_to(&self, mut idx: usize) -> Path {
+        let mut titles = Vec::new();
+        while idx != 0 {
+            titles.push(self.articles[idx].clone());
+            idx = self.parent[idx];
+        }
+
+        titles.reverse();
+        Path::new(titles)
+    }
+
+    pub fn stats(&self) -> SearchStats {
+        SearchStats {
+            expanded: self.expanded,
+            discovered: self.articles.len() - 1,
+            max_depth: self.depth[1..].iter().copied().max().unwrap_or(0),
+        }
+    }
+
+    /// The number of shortest paths from the start to the article at `idx`,
+    /// and the first `limit` of them. Only the path to `idx` is known unless
+    /// the search ran with `all_shortest`.
+    pub fn shortest_paths(&self, idx: usize, limit: usize) -> (u128, Vec<Path>) {
+        let parents = |idx: usize| {
+            std::iter::once(self.parent[idx])
+                .chain(self.other_parents.get(&idx).into_iter().flatten().copied())
+        };
+
+        // Parents are discovered before their children
+        let mut counts = vec![0u128; idx + 1];
+        counts[1] = 1;
+        for i in 2..=idx {
+            counts[i] = parents(i).fold(0u128, |n, p| n.saturating_add(counts[p]));
+        }
+
+        // Walk back from `idx`, trying every parent in turn
+        let mut paths = Vec::new();
+        let mut stack = vec![vec![idx]];
+        while let Some(rev) = stack.pop() {
+            if paths.len() >= limit {
+                break;
+            }
+            let last = *rev.last().unwrap();
+            if last == 1 {
+                paths.push(Path::new(
+                    rev.iter()
+                        .rev()
+                        .map(|&i| self.articles[i].clone())
+                        .collect(),
+                ));
+                continue;
+            }
+            let mut branches: Vec<usize> = parents(last).collect();
+            branches.reverse();
+            for p in branches {
+                let mut next = rev.clone();
+                next.push(p);
+                stack.push(next);
+            }
+        }
+
+        (counts[idx], paths)
+    }
+}
+
+/// Report an article whose links couldn't be fetched. Links to missing pages
+/// are dead ends every search runs into, so they're only shown in verbose
+/// mode.
+fn fetch_failed(opts: &Options, err: &Error) {
+    if err.is::<Missing>() {
+        if opts.verbose {
+            println!("{}, skipped", err);
+        }
+    } else {
+        eprintln!("{}", err);
+    }
+}
+
+/// Whether the search has to stop before fetching another article, having
+/// fetched `fetched`. The audit log is told why.
+fn must_stop(wiki: &Wiki, opts: &Options, fetched: usize) -> bool {
+    let reason = if opts.budget.is_some_and(|budget| fetched >= budget) {
+        "budget"
+    } else if opts
+        .cancel
+        .is_some_and(|cancel| cancel.load(AtomicOrdering::Relaxed))
+    {
+        "cancelled"
+    } else {
+        return false;
+    };
+
+    wiki.audit("stop", || json!({ "reason": reason, "fetched": fetched }));
+    true
+}
+
+/// Pause the search if asked to, telling how far it has got having fetched
+/// `fetched` articles and discovered those of `ex`, with `frontier` of them
+/// waiting, and the partial chains it would take further first
+fn checkpoint(
+    wiki: &Wiki,
+    opts: &Options,
+    ex: &Explored,
+    fetched: usize,
+    frontier: Option<usize>,
+    chains: impl FnOnce() -> Vec<Path>,
+) {
+    let Some(pause) = opts.pause else {
+        return;
+    };
+
+    pause.checkpoint(|| {
+        let stats = ex.stats();
+        let waiting = frontier.map_or(String::new(), |n| format!(", {} in the frontier", n));
+        eprintln!(
+            "Fetched {} articles with {} requests, discovered {}{}, {} links deep",
+            fetched,
+            wiki.timings().requests,
+            stats.discovered,
+            waiting,
+            stats.max_depth
+        );
+        let chains = chains();
+        if !chains.is_empty() {
+            eprintln!("Going further first:");
+        }
+        for chain in chains {
+            eprintln!("  {:?}", chain);
+        }
+    });
+}
+
+/// Why the link to `name`, at `pos` among the links of its article and not
+/// discovered before, isn't followed, if it isn't
+fn skip_reason(
+    opts: &Options,
+    excluded: &HashSet<String>,
+    pos: usize,
+    name: &str,
+) -> Option<&'static str> {
+    if opts.max_links.is_some_and(|max| pos >= max) {
+        Some("max_links")
+    } else if opts.scope.is_some_and(|scope| !scope.contains(name)) {
+        Some("scope")
+    } else if excluded.contains(name) {
+        Some("excluded_category")
+    } else {
+        None
+    }
+}
+
+/// Those of `links` the search hasn't `seen` that are in an excluded
+/// category. If that can't be told, none are.
+fn excluded(
+    wiki: &Wiki,
+    opts: &Options,
+    links: &[String],
+    seen: impl Fn(&str) -> bool,
+) -> HashSet<String> {
+    let Some(exclude) = opts.exclude else {
+        return HashSet::new();
+    };
+
+    let new: Vec<&str> = links
+        .iter()
+        .map(String::as_str)
+        .filter(|name| !seen(name))
+        .collect();
+    exclude.excluded(wiki, &new).unwrap_or_else(|err| {
+        eprintln!("{}", err);
+        HashSet::new()
+    })
+}
+
+/// Record in the audit log what the search made of the link from `article`
+/// to `link`
+fn audit_link(wiki: &Wiki, article: &str, link: &str, decision: &str) {
+    wiki.audit(
+        "follow",
+        || json!({ "article": article, "link": link, "decision": decision }),
+    );
+}
+
+/// Count an article of `depth`, waited for from `fetch_start` on and fetched
+/// with `requests` requests, into the last of `levels`
+fn tally(levels: &mut Vec<Level>, depth: u32, fetch_start: Instant, requests: u32) {
+    if levels.last().is_none_or(|level| level.depth != depth) {
+        levels.push(Level {
+            depth,
+            fetched: 0,
+            requests: 0,
+            elapsed: Duration::ZERO,
+        });
+    }
+
+    let level = levels.last_mut().unwrap();
+    level.fetched += 1;
+    level.requests += requests;
+    level.elapsed += fetch_start.elapsed();
+}
+
+/// Links an article's fetch gave, and the number of requests it took
+type Fetched = (Result<Vec<String>, Error>, u32);
+
+/// Links of `article`, at `depth`, the search may follow, and the number of
+/// requests fetching them took
+fn fetch(wiki: &Wiki, events: &Events, opts: &Options, article: &str, depth: u32) -> Fetched {
+    events.emit(
+        "fetch_started",
+        json!({ "article": article, "depth": depth }),
+    );
+
+    // Counted on this thread alone, as others may be fetching meanwhile
+    let sent = wiki::thread_requests();
+    let links = links(wiki, opts, article);
+    (links, wiki::thread_requests() - sent)
+}
+
+/// Links of `article` the search may follow
+fn links(wiki: &Wiki, opts: &Options, article: &str) -> Result<Vec<String>, Error> {
+    let links = wiki.links(article)?;
+    let Some(plugin) = opts.plugin else {
+        return Ok(links);
+    };
+
+    // To tell the audit log which links the plugin dropped
+    let all = links.clone();
+    let kept = plugin.filter(article, links)?;
+    if kept.len() < all.len() {
+        let kept_set: HashSet<&String> = kept.iter().collect();
+        for link in all.iter().filter(|l| !kept_set.contains(l)) {
+            audit_link(wiki, article, link, "plugin");
+        }
+    }
+
+    Ok(kept)
+}
+
+/// Links of the articles a breadth-first search is about to expand, fetched
+/// by worker threads ahead of it, so that several requests can be in flight
+/// while it goes through the links of one article
+#[derive(Default)]
+struct Ahead {
+    state: Mutex<AheadState>,
+    /// Signalled when articles are queued, fetched or the search is over
+    changed: Condvar,
+}
+
+#[derive(Default)]
+struct AheadState {
+    /// Articles to fetch, by index, with their depth, in the order they'll
+    /// be expanded
+    queue: VecDeque<(usize, String, u32)>,
+    /// Articles before this one were queued already
+    queued_to: usize,
+    fetching: HashSet<usize>,
+    /// Links fetched, with the article they are of
+    fetched: HashMap<usize, (String, Fetched)>,
+    over: bool,
+}
+
+impl Ahead {
+    /// Fetch queued articles until the search is over, none while it is
+    /// paused
+    fn work(&self, wiki: &Wiki, events: &Events, opts: &Options) {
+        let mut state = self.state.lock().unwrap();
+        loop {
+            if state.over {
+                return;
+            }
+            if state.queue.is_empty() {
+                state = self.changed.wait(state).unwrap();
+                continue;
+            }
+            if let Some(pause) = opts.pause.filter(|pause| pause.paused()) {
+                drop(state);
+                pause.hold();
+                state = self.state.lock().unwrap();
+                continue;
+            }
+            let Some((idx, article, depth)) = state.queue.pop_front() else {
+                continue;
+            };
+            state.fetching.insert(idx);
+            drop(state);
+
+            let fetched = fetch(wiki, events, opts, &article, depth);
+
+            state = self.state.lock().unwrap();
+            state.fetching.remove(&idx);
+            state.fetched.insert(idx, (article, fetched));
+            self.changed.notify_all();
+        }
+    }
+
+    /// Queue the articles of `ex` from `from` up to `to`, but none past the
+    /// maximum depth, which aren't expanded
+    fn queue(&self, ex: &Explored, max_depth: u32, from: usize, to: usize) {
+        let mut state = self.state.lock().unwrap();
+        let from = from.max(state.queued_to);
+        for idx in from..to.min(ex.articles.len()) {
+            if ex.depth[idx] > max_depth {
+                break;
+            }
+            state
+                .queue
+                .push_back((idx, ex.articles[idx].clone(), ex.depth[idx]));
+            state.queued_to = idx + 1;
+        }
+        self.changed.notify_all();
+    }
+
+    /// The links of `article`, at `idx`, and the requests they took, waiting
+    /// for them if they're being fetched, or `None` if no worker got to it
+    fn take(&self, idx: usize, article: &str) -> Option<Fetched> {
+        let mut state = self.state.lock().unwrap();
+        loop {
+            // Another article had the index before the frontier was pruned
+            if let Some((name, fetched)) = state.fetched.remove(&idx) {
+                return (name == article).then_some(fetched);
+            }
+            if !state.fetching.contains(&idx) {
+                state.queue.retain(|(i, ..)| *i != idx);
+                return None;
+            }
+            state = self.changed.wait(state).unwrap();
+        }
+    }
+
+    /// Drop the articles from `idx` on, pruned from the frontier
+    fn forget(&self, idx: usize) {
+        let mut state = self.state.lock().unwrap();
+        state.queue.retain(|(i, ..)| *i < idx);
+        state.fetched.retain(|i, _| *i < idx);
+        state.queued_to = state.queued_to.min(idx);
+    }
+
+    /// Let the workers go once they're done with what they're fetching
+    fn end(&self) {
+        let mut state = self.state.lock().unwrap();
+        state.over = true;
+        state.queue.clear();
+        self.changed.notify_all();
+    }
+}
+
+/// Memory a breadth-first search saves once it nears the cap
+struct Saving {
+    /// Names of the articles expanded before the last spill
+    spill: Spill,
+    /// Hashes of the spilled names, to tell links to them apart
+    spilled: HashSet<u64>,
+    hasher: RandomState,
+}
+
+impl Saving {
+    fn spilled(&self, name: &str) -> bool {
+        self.spilled.contains(&self.hasher.hash_one(name))
+    }
+
+    /// Spill the names of the articles before `to`, telling why not if
+    /// they can't be
+    fn spill(&mut self, ex: &mut Explored, article_idx: &mut HashMap<String, usize>, to: usize) {
+        let from = self.spill.spilled_to();
+        for name in &ex.articles[from..to] {
+            article_idx.remove(name);
+            self.spilled.insert(self.hasher.hash_one(name));
+        }
+        article_idx.shrink_to_fit();
+
+        if let Err(err) = self.spill.spill(&mut ex.articles, to) {
+            eprintln!("Spilling articles to disk: {}", err);
+        }
+    }
+
+    /// Path to the article at `idx`, with the names spilled read back
+    fn path_to(&self, ex: &Explored, mut idx: usize) -> Path {
+        let mut titles = Vec::new();
+        while idx != 0 {
+            titles.push(if idx < self.spill.spilled_to() {
+                self.spill.name(idx).unwrap_or_else(|err| {
+                    eprintln!("Reading a spilled article: {}", err);
+                    String::new()
+                })
+            } else {
+                ex.articles[idx].clone()
+            });
+            idx = ex.parent[idx];
+        }
+
+        titles.reverse();
+        Path::new(titles)
+    }
+}
+
+/// Path to the article at `idx` of `ex`, whether or not names were spilled
+fn saved_path(ex: &Explored, saving: Option<&Saving>, idx: usize) -> Path {
+    match saving {
+        Some(saving) => saving.path_to(ex, idx),
+        None => ex.path_to(idx),
+    }
+}
+
+/// Start saving memory while expanding the article at `curr_idx`, of
+/// `depth`: drop what the search keeps besides the tree, prune the latter
+/// half of the next level discovered so far, and spill the articles
+/// expanded so far
+fn start_saving(
+    wiki: &Wiki,
+    ex: &mut Explored,
+    article_idx: &mut HashMap<String, usize>,
+    ahead: Option<&Ahead>,
+    curr_idx: usize,
+    depth: u32,
+) -> Option<Saving> {
+    ex.edges = Vec::new();
+    ex.other_parents = HashMap::new();
+
+    // The next level is at the end, and ends found stay
+    let next = ex
+        .depth
+        .iter()
+        .rposition(|&d| d <= depth)
+        .map_or(0, |i| i + 1);
+    let cut = (next + (ex.articles.len() - next) / 2)
+        .max(ex.ends.last().map_or(0, |&end| end + 1))
+        .max(curr_idx + 1);
+    let pruned = ex.articles.len().saturating_sub(cut);
+    for name in ex.articles.drain(cut.min(ex.articles.len())..) {
+        article_idx.remove(&name);
+    }
+    ex.parent.truncate(ex.articles.len());
+    ex.depth.truncate(ex.articles.len());
+    if let Some(ahead) = ahead {
+        ahead.forget(cut);
+    }
+    wiki.audit(
+        "prune",
+        || json!({ "articles": pruned, "depth": depth + 1 }),
+    );
+    match pruned {
+        0 => eprintln!("Memory use is near the cap, spilling expanded articles to disk"),
+        n => eprintln!(
+            "Memory use is near the cap, spilling expanded articles to disk and pruning {} from the frontier",
+            n
+        ),
+    }
+
+    let mut saving = match Spill::create() {
+        Ok(spill) => Saving {
+            spill,
+            spilled: HashSet::new(),
+            hasher: RandomState::new(),
+        },
+        Err(err) => {
+            eprintln!("Spilling articles to disk: {}", err);
+            return None;
+        }
+    };
+    saving.spill(ex, article_idx, curr_idx);
+    Some(saving)
+}
+
+/// Breadth-first search from `start` to `end`, calling `on_path` with every
+/// path found. Without an `end` the search explores up to the maximum depth.
+///
+/// Online, the articles next in line are fetched ahead, as many at once as
+/// the wiki's lanes let through.
+///
+/// With a seed from the same start the search carries on from the first
+/// article the seed didn't process, reporting the paths to articles it
+/// already discovered without fetching anything.
+pub fn bfs(
+    wiki: &Wiki,
+    events: &Events,
+    opts: &Options,
+    start: &str,
+    end: Option<&Target>,
+    on_path: impl FnMut(&Path),
+) -> Explored {
+    let workers = wiki.parallel_fetches();
+    if workers <= 1 {
+        return bfs_with(wiki, events, opts, start, end, None, on_path);
+    }
+
+    let ahead = Ahead::default();
+    thread::scope(|s| {
+        for _ in 0..workers {
+            s.spawn(|| ahead.work(wiki, events, opts));
+        }
+        let ex = bfs_with(wiki, events, opts, start, end, Some(&ahead), on_path);
+        ahead.end();
+        ex
+    })
+}
+
+fn bfs_with(
+    wiki: &Wiki,
+    events: &Events,
+    opts: &Options,
+    start: &str,
+    end: Option<&Target>,
+    ahead: Option<&Ahead>,
+    mut on_path: impl FnMut(&Path),
+) -> Explored {
+    let mut ex = match opts.seed {
+        Some(seed) if seed.articles[1] == start => Explored {
+            found: false,
+            ends: Vec::new(),
+            other_parents: HashMap::new(),
+            levels: Vec::new(),
+            ..seed.clone()
+        },
+        _ => Explored::new(start),
+    };
+    let mut article_idx: HashMap<String, usize> = (1..ex.articles.len())
+        .map(|idx| (ex.articles[idx].clone(), idx))
+        .collect();
+
+    if let Some(end) = end {
+        for idx in 2..ex.articles.len() {
+            if !end.matches(&ex.articles[idx]) {
+                continue;
+            }
+            ex.found = true;
+            ex.ends.push(idx);
+
+            let path = ex.path_to(idx);
+            on_path(&path);
+
+            events.emit("target_found", path.to_json(wiki.project()));
+
+            if !opts.all && !opts.all_shortest {
+                return ex;
+            }
+        }
+    }
+
+    // Near the memory cap names are spilled and the frontier is pruned; at
+    // it only the current frontier is searched: newly discovered articles
+    // are checked against the end but not kept
+    let mut saving: Option<Saving> = None;
+    let mut frugal = false;
+    let mut record_edges = opts.record_edges || opts.db.is_some();
+
+    let mut db = opts
+        .db
+        .and_then(|db| match db.start_run(start, end.map(|end| end.title())) {
+            Ok(run) => Some((db, run)),
+            Err(err) => {
+                eprintln!("{}", err);
+                None
+            }
+        });
+
+    let mut fetched = 0;
+    let mut curr_idx = ex.processed;
+    let first_depth = ex.depth.get(curr_idx + 1).copied().unwrap_or(0);
+
+    'search: for depth in first_depth..(opts.max_depth + 1) {
+        // Paths found on the previous level are the shortest
+        if opts.all_shortest && ex.found {
+            break;
+        }
+
+        // Articles are discovered level by level
+        while ex.depth.get(curr_idx + 1) == Some(&depth) {
+            curr_idx += 1;
+
+            checkpoint(
+                wiki,
+                opts,
+                &ex,
+                fetched,
+                Some(ex.articles.len() - curr_idx),
+                || {
+                    (curr_idx..ex.articles.len().min(curr_idx + PAUSE_CHAINS))
+                        .map(|idx| saved_path(&ex, saving.as_ref(), idx))
+                        .collect()
+                },
+            );
+            if must_stop(wiki, opts, fetched) {
+                break 'search;
+            }
+            fetched += 1;
+
+            let article = &ex.articles[curr_idx];
+
+            if opts.verbose {
+                println!("{} {}", article, depth);
+            }
+            wiki.audit("expand", || json!({ "article": article, "depth": depth }));
+
+            let fetch_start = Instant::now();
+            if let Some(ahead) = ahead {
+                // Not past the budget
+                let left = opts.budget.map_or(usize::MAX, |budget| budget - fetched);
+                let to = curr_idx + 1 + left.min(2 * wiki.parallel_fetches());
+                ahead.queue(&ex, opts.max_depth, curr_idx + 1, to);
+            }
+            let (links, requests) = ahead
+                .and_then(|ahead| ahead.take(curr_idx, article))
+                .unwrap_or_else(|| fetch(wiki, events, opts, article, depth));
+            tally(&mut ex.levels, depth, fetch_start, requests);
+            let links = match links {
+                Ok(links) => links,
+                Err(err) => {
+                    fetch_failed(opts, &err);
+                    continue;
+                }
+            };
+            ex.expanded += 1;
+            let excluded = excluded(wiki, opts, &links, |name| article_idx.contains_key(name));
+
+            if let (false, Some(max)) = (frugal, opts.max_memory) {
+                let rss = mem::rss().unwrap_or(0);
+                if saving.is_none() && rss as f64 >= max as f64 * MEMORY_HIGH_WATER {
+                    record_edges = false;
+                    saving = start_saving(wiki, &mut ex, &mut article_idx, ahead, curr_idx, depth);
+                    // Articles discovered next take the indices of the pruned
+                    if let Some((conn, run)) = db {
+                        if let Err(err) = conn.pruned(run, ex.articles.len()) {
+                            eprintln!("{}", err);
+                            db = None;
+                        }
+                    }
+                } else if let Some(saving) = &mut saving {
+                    if curr_idx >= saving.spill.spilled_to() + SPILL_EVERY {
+                        saving.spill(&mut ex, &mut article_idx, curr_idx);
+                    }
+                }
+                if rss >= max {
+                    eprintln!(
+                        "Memory use reached {} MiB, no longer adding articles to the frontier",
+                        max / (1024 * 1024)
+                    );
+                    frugal = true;
+                    record_edges = false;
+                    ex.edges = Vec::new();
+                }
+            }
+
+            let mut new_links = 0;
+            let (first_new, first_edge) = (ex.articles.len(), ex.edges.len());
+            // Set once a path ends the search, which still records this article
+            let mut done = false;
+
+            for (pos, name) in links.iter().enumerate() {
+                if saving.as_ref().is_some_and(|saving| saving.spilled(name)) {
+                    audit_link(wiki, &ex.articles[curr_idx], name, "seen");
+                    continue;
+                }
+                if let Some(&idx) = article_idx.get(name) {
+                    audit_link(wiki, &ex.articles[curr_idx], name, "seen");
+                    if record_edges {
+                        ex.edges.push((curr_idx, idx));
+                    }
+                    if opts.all_shortest
+                        && saving.is_none()
+                        && ex.depth[idx] == depth + 1
+                        && ex.parent[idx] != curr_idx
+                    {
+                        let others = ex.other_parents.entry(idx).or_default();
+                        if others.last() != Some(&curr_idx) {
+                            others.push(curr_idx);
+                        }
+                    }
+                    continue;
+                }
+                let is_end = end.is_some_and(|end| end.matches(name));
+                let skipped = if frugal {
+                    Some("memory")
+                } else {
+                    skip_reason(opts, &excluded, pos, name)
+                };
+                let decision = match (skipped, is_end) {
+                    (_, true) => "end",
+                    (Some(reason), false) => reason,
+                    (None, false) => "queued",
+                };
+                audit_link(wiki, &ex.articles[curr_idx], name, decision);
+                if skipped.is_some() && !is_end {
+                    continue;
+                }
+
+                ex.articles.push(name.clone());
+                let idx = ex.articles.len() - 1;
+                article_idx.insert(name.clone(), idx);
+                ex.parent.push(curr_idx);
+                ex.depth.push(depth + 1);
+
+                if record_edges {
+                    ex.edges.push((curr_idx, idx));
+                }
+
+                new_links += 1;
+
+                if is_end {
+                    ex.found = true;
+                    ex.ends.push(idx);
+
+                    let path = saved_path(&ex, saving.as_ref(), idx);
+                    on_path(&path);
+
+                    events.emit("target_found", path.to_json(wiki.project()));
+
+                    if !opts.all && !opts.all_shortest {
+                        done = true;
+                        break;
+                    }
+                }
+            }
+            // A search picking up from here needs every link of the article,
+            // which it doesn't get once articles stop being kept
+            if !frugal && !done {
+                ex.processed = curr_idx;
+            }
+
+            if let Some((conn, run)) = db {
+                if let Err(err) = conn.expanded(run, &ex, curr_idx, first_new, first_edge) {
+                    eprintln!("{}", err);
+                    db = None;
+                }
+            }
+
+            events.emit(
+                "links_extracted",
+                json!({
+                    "article": &ex.articles[curr_idx],
+                    "depth": depth,
+                    "links": links.len(),
+                    "new": new_links,
+                }),
+            );
+            if done {
+                break 'search;
+            }
+        }
+    }
+
+    // The result has every name
+    if let Some(saving) = &saving {
+        if let Err(err) = saving.spill.restore(&mut ex.articles) {
+            eprintln!("Reading back spilled articles: {}", err);
+        }
+    }
+
+    ex
+}
+
+/// Breadth-first search from `end` back to `start` over the articles linking
+/// to each, calling `on_path` with the path found, which runs from `start`.
+///
+/// The articles of the result are discovered from `end`, and their depths
+/// are distances to it, while its edges point the way the links do. Up to
+/// 500 backlinks of each article are followed, and the search stops at the
+/// first path; `all`, `all_shortest`, `max_memory` and `db` don't apply.
+pub fn backward(
+    wiki: &Wiki,
+    events: &Events,
+    opts: &Options,
+    start: &str,
+    end: &Target,
+    mut on_path: impl FnMut(&Path),
+) -> Explored {
+    let mut ex = Explored::new(end.title());
+    let mut article_idx = HashMap::from([(end.title().to_string(), 1)]);
+
+    let mut fetched = 0;
+    let mut curr_idx = 1;
+    'search: while curr_idx < ex.articles.len() {
+        // Chains run the way the links go, ending at the end
+        checkpoint(
+            wiki,
+            opts,
+            &ex,
+            fetched,
+            Some(ex.articles.len() - curr_idx),
+            || {
+                (curr_idx..ex.articles.len().min(curr_idx + PAUSE_CHAINS))
+                    .map(|idx| {
+                        let mut titles = ex.path_to(idx).titles().to_vec();
+                        titles.reverse();
+                        Path::new(titles)
+                    })
+                    .collect()
+            },
+        );
+        let depth = ex.depth[curr_idx];
+        if depth > opts.max_depth || must_stop(wiki, opts, fetched) {
+            break;
+        }
+        fetched += 1;
+
+        let article = &ex.articles[curr_idx];
+
+        if opts.verbose {
+            println!("{} {}", article, depth);
+        }
+        wiki.audit("expand", || json!({ "article": article, "depth": depth }));
+
+        events.emit(
+            "fetch_started",
+            json!({ "article": article, "depth": depth }),
+        );
+
+        let (fetch_start, sent) = (Instant::now(), wiki::thread_requests());
+        let backlinks = wiki.backlinks(article);
+        tally(
+            &mut ex.levels,
+            depth,
+            fetch_start,
+            wiki::thread_requests() - sent,
+        );
+        let backlinks = match backlinks {
+            Ok(backlinks) => backlinks,
+            Err(err) => {
+                fetch_failed(opts, &err);
+                curr_idx += 1;
+                continue;
+            }
+        };
+        ex.expanded += 1;
+        let excluded = excluded(wiki, opts, &backlinks, |name| {
+            article_idx.contains_key(name)
+        });
+
+        let mut new_links = 0;
+        for (pos, name) in backlinks.iter().enumerate() {
+            // Recorded the way the links go
+            if let Some(&idx) = article_idx.get(name) {
+                audit_link(wiki, name, &ex.articles[curr_idx], "seen");
+                if opts.record_edges {
+                    ex.edges.push((idx, curr_idx));
+                }
+                continue;
+            }
+            let is_start = name == start;
+            let skipped = skip_reason(opts, &excluded, pos, name);
+            let decision = match (skipped, is_start) {
+                (_, true) => "start",
+                (Some(reason), false) => reason,
+                (None, false) => "queued",
+            };
+            audit_link(wiki, name, &ex.articles[curr_idx], decision);
+            if skipped.is_some() && !is_start {
+                continue;
+            }
+
+            ex.articles.push(name.clone());
+            let idx = ex.articles.len() - 1;
+            article_idx.insert(name.clone(), idx);
+            ex.parent.push(curr_idx);
+            ex.depth.push(depth + 1);
+
+            if opts.record_edges {
+                ex.edges.push((idx, curr_idx));
+            }
+
+            new_links += 1;
+
+            if is_start {
+                ex.found = true;
+                ex.ends.push(idx);
+
+                let mut titles = ex.path_to(idx).titles().to_vec();
+                titles.reverse();
+                let path = Path::new(titles);
+                on_path(&path);
+
+                events.emit("target_found", path.to_json(wiki.project()));
+
+                break 'search;
+            }
+        }
+
+        events.emit(
+            "links_extracted",
+            json!({
+                "article": &ex.articles[curr_idx],
+                "depth": depth,
+                "links": backlinks.len(),
+                "new": new_links,
+            }),
+        );
+        curr_idx += 1;
+    }
+
+    ex
+}
+
+/// Random walks from `start` following links at random, never back to an
+/// article of the same walk, each until it links to `end`, reaches a dead
+/// end or the maximum depth, or can no longer beat the best path found.
+/// Walks start over until the budget of articles, `WALK_BUDGET` unless
+/// given, is used up, then `on_path` is called with the best path, which
+/// needn't be the shortest there is.
+///
+/// Articles are fetched once however many walks go through them. Every
+/// step of every walk is an article of the result, so articles can appear
+/// several times; `all`, `all_shortest`, `max_memory` and `db` don't apply.
+pub fn random_walks(
+    wiki: &Wiki,
+    events: &Events,
+    opts: &Options,
+    start: &str,
+    end: &Target,
+    mut on_path: impl FnMut(&Path),
+) -> Explored {
+    let opts = Options {
+        budget: Some(opts.budget.unwrap_or(WALK_BUDGET)),
+        ..*opts
+    };
+    let mut ex = Explored::new(start);
+    let mut known: HashMap<String, Vec<String>> = HashMap::new();
+    let hasher = RandomState::new();
+
+    let mut best: Option<usize> = None;
+    let (mut fetched, mut steps, mut idle) = (0, 0u64, 0);
+    'walks: while idle < MAX_IDLE_WALKS {
+        let fetched_before = fetched;
+        let mut curr_idx = 1;
+        let mut on_walk = HashSet::from([start.to_string()]);
+
+        loop {
+            let depth = ex.depth[curr_idx];
+            // Going further can only tie with the best path
+            if depth > opts.max_depth || best.is_some_and(|idx| depth + 1 >= ex.depth[idx]) {
+                break;
+            }
+
+            let article = ex.articles[curr_idx].clone();
+            if !known.contains_key(&article) {
+                // The walk so far, and the best path
+                checkpoint(wiki, &opts, &ex, fetched, None, || {
+                    std::iter::once(curr_idx)
+                        .chain(best)
+                        .map(|idx| ex.path_to(idx))
+                        .collect()
+                });
+                if must_stop(wiki, &opts, fetched) {
+                    break 'walks;
+                }
+                fetched += 1;
+
+                if opts.verbose {
+                    println!("{} {}", article, depth);
+                }
+                wiki.audit("expand", || json!({ "article": article, "depth": depth }));
+                events.emit(
+                    "fetch_started",
+                    json!({ "article": article, "depth": depth }),
+                );
+
+                let mut links = match links(wiki, &opts, &article) {
+                    Ok(links) => {
+                        ex.expanded += 1;
+                        links
+                    }
+                    Err(err) => {
+                        fetch_failed(&opts, &err);
+                        Vec::new()
+                    }
+                };
+                let excluded = excluded(wiki, &opts, &links, |name| known.contains_key(name));
+                for link in links
+                    .iter()
+                    .filter(|l| excluded.contains(*l) && !end.matches(l))
+                {
+                    audit_link(wiki, &article, link, "excluded_category");
+                }
+                links.retain(|l| !excluded.contains(l) || end.matches(l));
+                known.insert(article.clone(), links);
+            }
+            let links = &known[&article];
+
+            if let Some(name) = links.iter().find(|l| end.matches(l)) {
+                ex.articles.push(name.clone());
+                ex.parent.push(curr_idx);
+                ex.depth.push(depth + 1);
+                ex.found = true;
+                best = Some(ex.articles.len() - 1);
+                wiki.audit(
+                    "walk_end",
+                    || json!({ "article": &article, "reason": "end", "length": depth + 1 }),
+                );
+                break;
+            }
+
+            let choices: Vec<&String> = links
+                .iter()
+                .take(opts.max_links.unwrap_or(usize::MAX))
+                .filter(|l| !on_walk.contains(*l))
+                .filter(|l| opts.scope.is_none_or(|scope| scope.contains(*l)))
+                .collect();
+            if choices.is_empty() {
+                wiki.audit(
+                    "walk_end",
+                    || json!({ "article": &article, "reason": "dead_end" }),
+                );
+                break;
+            }
+            steps += 1;
+            let next = choices[hasher.hash_one(steps) as usize % choices.len()].clone();
+            audit_link(wiki, &article, &next, "walked");
+
+            on_walk.insert(next.clone());
+            ex.articles.push(next);
+            ex.parent.push(curr_idx);
+            ex.depth.push(depth + 1);
+            curr_idx = ex.articles.len() - 1;
+        }
+
+        idle = if fetched == fetched_before {
+            idle + 1
+        } else {
+            0
+        };
+    }
+
+    if let Some(idx) = best {
+        ex.ends.push(idx);
+
+        let path = ex.path_to(idx);
+        on_path(&path);
+
+        events.emit("target_found", path.to_json(wiki.project()));
+    }
+
+    ex
+}
+
+/// Search from `start` to `end` with `strategy`, calling `on_path` with every
+/// path found
+pub fn search(
+    wiki: &Wiki,
+    events: &Events,
+    opts: &Options,
+    strategy: Strategy,
+    start: &str,
+    end: &Target,
+    on_path: impl FnMut(&Path),
+) -> Result<Explored, Error> {
+    match strategy {
+        Strategy::Backlinks => return Ok(backward(wiki, events, opts, start, end, on_path)),
+        Strategy::RandomWalk => return Ok(random_walks(wiki, events, opts, start, end, on_path)),
+        _ => {}
+    }
+
+    Ok(
+        match strategy.heuristic(wiki, opts.embeddings, opts.plugin, end.title())? {
+            Some(heuristic) => best_first(wiki, events, opts, start, end, &*heuristic, on_path),
+            None => bfs(wiki, events, opts, start, Some(end), on_path),
+        },
+    )
+}
+
+/// Search from `start` to `end` with both `strategies` at once, sharing the
+/// wiki and its rate limit, and stop the other as soon as one finds a path.
+/// Returns the strategy that found it, or the first if neither did, and its
+/// result; only the winner's paths are passed to `on_path`.
+pub fn race(
+    wiki: &Wiki,
+    events: &Events,
+    opts: &Options,
+    strategies: [Strategy; 2],
+    start: &str,
+    end: &Target,
+    mut on_path: impl FnMut(&Path),
+) -> Result<(Strategy, Explored), Error> {
+    let done = AtomicBool::new(false);
+    let opts = Options {
+        cancel: Some(&done),
+        ..*opts
+    };
+
+    let results = thread::scope(|s| {
+        let runners = strategies.map(|strategy| {
+            let (opts, done) = (&opts, &done);
+            s.spawn(move || {
+                let mut paths = Vec::new();
+                let mut won = false;
+                let result = search(wiki, events, opts, strategy, start, end, |path| {
+                    // The first to get here wins, a path found by the other
+                    // meanwhile comes too late
+                    if !won {
+                        won = done
+                            .compare_exchange(
+                                false,
+                                true,
+                                AtomicOrdering::Relaxed,
+                                AtomicOrdering::Relaxed,
+                            )
+                            .is_ok();
+                    }
+                    if won {
+                        paths.push(path.clone());
+                    }
+                });
+                (won, paths, result)
+            })
+        });
+        runners.map(|runner| runner.join().unwrap())
+    });
+
+    let winner = results.iter().position(|(won, ..)| *won);
+    for (strategy, (won, _, result)) in strategies.iter().zip(&results) {
+        match result {
+            Ok(explored) if *won => eprintln!(
+                "{} won after fetching {} articles",
+                strategy.name(),
+                explored.expanded
+            ),
+            Ok(explored) if winner.is_some() => eprintln!(
+                "{} was stopped after fetching {} articles",
+                strategy.name(),
+                explored.expanded
+            ),
+            Ok(explored) => eprintln!(
+                "{} found no path after fetching {} articles",
+                strategy.name(),
+                explored.expanded
+            ),
+            Err(err) => eprintln!("{}: {}", strategy.name(), err),
+        }
+    }
+
+    // The winner, or failing that the first to search without an error
+    let pick = winner
+        .or_else(|| results.iter().position(|(_, _, result)| result.is_ok()))
+        .unwrap_or(0);
+    let (_, paths, result) = results.into_iter().nth(pick).unwrap();
+    paths.iter().for_each(&mut on_path);
+
+    result.map(|explored| (strategies[pick], explored))
+}
+
+/// Search with each of `stages` in turn until one finds a path, each
+/// fetching at most its budget of articles. With the wiki's link cache
+/// enabled, a stage taking over doesn't fetch again what the ones before it
+/// did.
+pub fn chain(
+    wiki: &Wiki,
+    events: &Events,
+    opts: &Options,
+    stages: &[Stage],
+    start: &str,
+    end: &Target,
+    mut on_path: impl FnMut(&Path),
+) -> Result<Explored, Error> {
+    let mut result = Err("no strategy to search with".into());
+
+    for (n, stage) in stages.iter().enumerate() {
+        let opts = Options {
+            budget: stage.budget.into_iter().chain(opts.budget).min(),
+            ..*opts
+        };
+        result = search(
+            wiki,
+            events,
+            &opts,
+            stage.strategy,
+            start,
+            end,
+            &mut on_path,
+        );
+
+        let Some(next) = stages.get(n + 1) else {
+            break;
+        };
+        match &result {
+            Ok(explored) if explored.found => break,
+            Ok(explored) => eprintln!(
+                "No path found with {} after fetching {} articles, falling back to {}",
+                stage.strategy.name(),
+                explored.expanded,
+                next.strategy.name()
+            ),
+            Err(err) => eprintln!(
+                "{}: {}, falling back to {}",
+                stage.strategy.name(),
+                err,
+                next.strategy.name()
+            ),
+        }
+    }
+
+    result
+}
+
+/// An article waiting to be expanded by `best_first`
+struct Candidate {
+    score: f64,
+    idx: usize,
+}
+
+impl Ord for Candidate {
+    /// Highest score first, then first discovered
+    fn cmp(&self, other: &Self) -> Ordering {
+        self.score
+            .total_cmp(&other.score)
+            .then_with(|| other.idx.cmp(&self.idx))
+    }
+}
+
+impl PartialOrd for Candidate {
+    fn partial_cmp(&self, other: &Self) -> Option<Ordering> {
+        Some(self.cmp(other))
+    }
+}
+
+impl PartialEq for Candidate {
+    fn eq(&self, other: &Self) -> bool {
+        self.cmp(other) == Ordering::Equal
+    }
+}
+
+impl Eq for Candidate {}
+
+/// Best-first search from `start` to `end`, always expanding the article
+/// `heuristic` scores highest. The path found isn't necessarily the
+/// shortest, and the search stops at the first one; `all`, `all_shortest`
+/// and `max_memory` don't apply.
+pub fn best_first(
+    wiki: &Wiki,
+    events: &Events,
+    opts: &Options,
+    start: &str,
+    end: &Target,
+    heuristic: &dyn Heuristic,
+    mut on_path: impl FnMut(&Path),
+) -> Explored {
+    let mut ex = Explored::new(start);
+    let mut article_idx = HashMap::from([(start.to_string(), 1)]);
+    let mut frontier = BinaryHeap::from([Candidate { score: 0.0, idx: 1 }]);
+    let record_edges = opts.record_edges || opts.db.is_some();
+
+    let mut db = opts
+        .db
+        .and_then(|db| match db.start_run(start, Some(end.title())) {
+            Ok(run) => Some((db, run)),
+            Err(err) => {
+                eprintln!("{}", err);
+                None
+            }
+        });
+
+    let mut fetched = 0;
+    while let Some(Candidate {
+        idx: curr_idx,
+        score,
+    }) = frontier.pop()
+    {
+        let depth = ex.depth[curr_idx];
+        if depth > opts.max_depth {
+            wiki.audit(
+                "drop",
+                || json!({ "article": &ex.articles[curr_idx], "reason": "max_depth" }),
+            );
+            continue;
+        }
+        // The chain being expanded and those scoring highest after it
+        checkpoint(wiki, opts, &ex, fetched, Some(frontier.len() + 1), || {
+            let mut next: Vec<&Candidate> = frontier.iter().collect();
+            next.sort_by(|a, b| b.cmp(a));
+            std::iter::once(curr_idx)
+                .chain(next.iter().map(|c| c.idx))
+                .take(PAUSE_CHAINS)
+                .map(|idx| ex.path_to(idx))
+                .collect()
+        });
+        if must_stop(wiki, opts, fetched) {
+            break;
+        }
+        fetched += 1;
+
+        let article = &ex.articles[curr_idx];
+
+        if opts.verbose {
+            println!("{} {}", article, depth);
+        }
+        wiki.audit(
+            "expand",
+            || json!({ "article": article, "depth": depth, "score": score }),
+        );
+
+        events.emit(
+            "fetch_started",
+            json!({ "article": article, "depth": depth }),
+        );
+
+        let links = match links(wiki, opts, article) {
+            Ok(links) => links,
+            Err(err) => {
+                fetch_failed(opts, &err);
+                continue;
+            }
+        };
+        ex.expanded += 1;
+        let excluded = excluded(wiki, opts, &links, |name| article_idx.contains_key(name));
+
+        let (first_new, first_edge) = (ex.articles.len(), ex.edges.len());
+        // Set once the path is found, which still records this article
+        let mut done = false;
+
+        for (pos, name) in links.iter().enumerate() {
+            if let Some(&idx) = article_idx.get(name) {
+                audit_link(wiki, &ex.articles[curr_idx], name, "seen");
+                if record_edges {
+                    ex.edges.push((curr_idx, idx));
+                }
+                continue;
+            }
+            let is_end = end.matches(name);
+            let skipped = skip_reason(opts, &excluded, pos, name);
+            let decision = match (skipped, is_end) {
+                (_, true) => "end",
+                (Some(reason), false) => reason,
+                (None, false) => "queued",
+            };
+            audit_link(wiki, &ex.articles[curr_idx], name, decision);
+            if skipped.is_some() && !is_end {
+                continue;
+            }
+
+            ex.articles.push(name.clone());
+            let idx = ex.articles.len() - 1;
+            article_idx.insert(name.clone(), idx);
+            ex.parent.push(curr_idx);
+            ex.depth.push(depth + 1);
+
+            if record_edges {
+                ex.edges.push((curr_idx, idx));
+            }
+
+            if is_end {
+                ex.found = true;
+                ex.ends.push(idx);
+
+                let path = ex.path_to(idx);
+                on_path(&path);
+
+                events.emit("target_found", path.to_json(wiki.project()));
+
+                done = true;
+                break;
+            }
+        }
+
+        let new = &ex.articles[first_new..];
+        // No more scores are needed once the path is found
+        let scores = if done {
+            Vec::new()
+        } else {
+            heuristic.scores(wiki, new).unwrap_or_else(|err| {
+                eprintln!("{}", err);
+                vec![0.0; new.len()]
+            })
+        };
+        for (idx, score) in (first_new..).zip(scores) {
+            wiki.audit(
+                "score",
+                || json!({ "article": &ex.articles[idx], "score": score }),
+            );
+            frontier.push(Candidate { score, idx });
+        }
+        if !done && heuristic.refreshed() {
+            wiki.audit("rescore", || json!({ "articles": frontier.len() }));
+            let waiting: Vec<usize> = frontier.drain().map(|c| c.idx).collect();
+            let names: Vec<String> = waiting.iter().map(|&i| ex.articles[i].clone()).collect();
+            match heuristic.scores(wiki, &names) {
+                Ok(scores) => frontier.extend(
+                    waiting
+                        .into_iter()
+                        .zip(scores)
+                        .map(|(idx, score)| Candidate { score, idx }),
+                ),
+                Err(err) => {
+                    eprintln!("{}", err);
+                    frontier.extend(waiting.into_iter().map(|idx| Candidate { score: 0.0, idx }));
+                }
+            }
+        }
+
+        if let Some((conn, run)) = db {
+            if let Err(err) = conn.expanded(run, &ex, curr_idx, first_new, first_edge) {
+                eprintln!("{}", err);
+                db = None;
+            }
+        }
+
+        events.emit(
+            "links_extracted",
+            json!({
+                "article": &ex.articles[curr_idx],
+                "depth": depth,
+                "links": links.len(),
+                "new": new.len(),
+            }),
+        );
+        if done {
+            break;
+        }
+    }
+
+    ex
+}
@@ -0,0 +1,110 @@
+use std::{
+    env,
+    fs::{self, File},
+    io::{self, BufReader, BufWriter, Read, Seek, SeekFrom, Write},
+    path::PathBuf,
+    process,
+    sync::{
+        atomic::{AtomicU64, Ordering},
+        Mutex,
+    },
+};
+
+/// Spill files created so far by this process
+static CREATED: AtomicU64 = AtomicU64::new(0);
+
+/// Names of articles a search moved out of memory to a temporary file,
+/// removed when this is dropped.
+///
+/// Names are stored back to back, from the article at index 1 on; the
+/// articles spilled so far are those before `spilled_to`.
+pub struct Spill {
+    path: PathBuf,
+    file: Mutex<File>,
+    /// Offset of the end of each spilled name
+    ends: Vec<u64>,
+}
+
+impl Spill {
+    /// Create the file, under a name of its own as searches running at once
+    /// each spill, never opening one already there
+    pub fn create() -> io::Result<Self> {
+        loop {
+            let n = CREATED.fetch_add(1, Ordering::Relaxed);
+            let path = env::temp_dir().join(format!("wiki-path-spill-{}-{}", process::id(), n));
+            match File::options()
+                .read(true)
+                .write(true)
+                .create_new(true)
+                .open(&path)
+            {
+                Ok(file) => {
+                    return Ok(Spill {
+                        path,
+                        file: Mutex::new(file),
+                        ends: Vec::new(),
+                    })
+                }
+                // Left by an earlier process with the same ID, or planted
+                Err(err) if err.kind() == io::ErrorKind::AlreadyExists => continue,
+                Err(err) => return Err(err),
+            }
+        }
+    }
+
+    /// Articles before this one are spilled
+    pub fn spilled_to(&self) -> usize {
+        self.ends.len() + 1
+    }
+
+    /// Move the names of the articles from `spilled_to` up to `to` out of
+    /// `names`, leaving them empty
+    pub fn spill(&mut self, names: &mut [String], to: usize) -> io::Result<()> {
+        let mut file = self.file.lock().unwrap();
+        let mut offset = file.seek(SeekFrom::End(0))?;
+
+        let mut out = BufWriter::new(&mut *file);
+        for name in &mut names[self.ends.len() + 1..to] {
+            out.write_all(name.as_bytes())?;
+            offset += name.len() as u64;
+            self.ends.push(offset);
+            *name = String::new();
+        }
+        out.flush()
+    }
+
+    /// Name of the spilled article at `idx`
+    pub fn name(&self, idx: usize) -> io::Result<String> {
+        let i = idx - 1;
+        let start = if i == 0 { 0 } else { self.ends[i - 1] };
+
+        let mut file = self.file.lock().unwrap();
+        file.seek(SeekFrom::Start(start))?;
+        let mut name = vec![0; (self.ends[i] - start) as usize];
+        file.read_exact(&mut name)?;
+
+        String::from_utf8(name).map_err(|err| io::Error::new(io::ErrorKind::InvalidData, err))
+    }
+
+    /// Put the spilled names back into `names`
+    pub fn restore(&self, names: &mut [String]) -> io::Result<()> {
+        let mut file = self.file.lock().unwrap();
+        file.seek(SeekFrom::Start(0))?;
+        let mut spilled = BufReader::new(&mut *file);
+
+        let mut start = 0;
+        for (name, &end) in names[1..].iter_mut().zip(&self.ends) {
+            let mut bytes = vec![0; (end - start) as usize];
+            spilled.read_exact(&mut bytes)?;
+            *name = String::from_utf8_lossy(&bytes).into_owned();
+            start = end;
+        }
+        Ok(())
+    }
+}
+
+impl Drop for Spill {
+    fn drop(&mut self) {
+        fs::remove_file(&self.path).ok();
+    }
+}
@@ -0,0 +1,129 @@
+use std::{path::Path, sync::Mutex};
+
+use rusqlite::{self as sql, params};
+
+use crate::search::Explored;
+
+const SCHEMA: &str = "
+CREATE TABLE IF NOT EXISTS runs (
+    id INTEGER PRIMARY KEY,
+    start TEXT NOT NULL,
+    \"end\" TEXT,
+    started_at TEXT NOT NULL
+);
+CREATE TABLE IF NOT EXISTS nodes (
+    run INTEGER NOT NULL REFERENCES runs(id),
+    id INTEGER NOT NULL,
+    title TEXT NOT NULL,
+    parent INTEGER,
+    depth INTEGER NOT NULL,
+    fetched_at TEXT,
+    PRIMARY KEY (run, id)
+);
+CREATE TABLE IF NOT EXISTS edges (
+    run INTEGER NOT NULL REFERENCES runs(id),
+    source INTEGER NOT NULL,
+    target INTEGER NOT NULL
+);
+";
+
+/// A SQLite database searches record the articles and links they discover
+/// into as they run.
+///
+/// Each search is a row of `runs`. Its articles are the `nodes` of the run,
+/// numbered in discovery order from 1 for the start, with the article they
+/// were discovered from as `parent` and the time their links were fetched,
+/// if they were. `edges` are the links seen between them.
+pub struct Db {
+    conn: Mutex<sql::Connection>,
+}
+
+impl Db {
+    pub fn open(path: &Path) -> sql::Result<Self> {
+        let conn = sql::Connection::open(path)?;
+        conn.execute_batch(SCHEMA)?;
+
+        Ok(Db {
+            conn: Mutex::new(conn),
+        })
+    }
+
+    /// Add a search from `start` to `end` and its start article, returning
+    /// the run ID
+    pub fn start_run(&self, start: &str, end: Option<&str>) -> sql::Result<i64> {
+        let mut conn = self.conn.lock().unwrap();
+        let tx = conn.transaction()?;
+
+        tx.execute(
+            "INSERT INTO runs (start, \"end\", started_at) VALUES (?1, ?2, ?3)",
+            params![start, end, jiff::Timestamp::now().to_string()],
+        )?;
+        let run = tx.last_insert_rowid();
+        tx.execute(
+            "INSERT INTO nodes (run, id, title, parent, depth) VALUES (?1, 1, ?2, NULL, 0)",
+            params![run, start],
+        )?;
+
+        tx.commit()?;
+        Ok(run)
+    }
+
+    /// Record that the links of article `idx` were fetched, discovering the
+    /// articles from `first_new` on and the edges from `first_edge` on
+    pub fn expanded(
+        &self,
+        run: i64,
+        ex: &Explored,
+        idx: usize,
+        first_new: usize,
+        first_edge: usize,
+    ) -> sql::Result<()> {
+        let mut conn = self.conn.lock().unwrap();
+        let tx = conn.transaction()?;
+
+        tx.execute(
+            "UPDATE nodes SET fetched_at = ?3 WHERE run = ?1 AND id = ?2",
+            params![run, idx as i64, jiff::Timestamp::now().to_string()],
+        )?;
+        {
+            let mut node = tx.prepare_cached(
+                "INSERT INTO nodes (run, id, title, parent, depth) VALUES (?1, ?2, ?3, ?4, ?5)",
+            )?;
+            for new in first_new..ex.articles.len() {
+                node.execute(params![
+                    run,
+                    new as i64,
+                    ex.articles[new],
+                    ex.parent[new] as i64,
+                    ex.depth[new],
+                ])?;
+            }
+
+            let mut edge =
+                tx.prepare_cached("INSERT INTO edges (run, source, target) VALUES (?1, ?2, ?3)")?;
+            for &(source, target) in &ex.edges[first_edge.min(ex.edges.len())..] {
+                edge.execute(params![run, source as i64, target as i64])?;
+            }
+        }
+
+        tx.commit()
+    }
+
+    /// Drop the articles of a run from `from` on, and the links to and from
+    /// them, once the search prunes them from its frontier
+    pub fn pruned(&self, run: i64, from: usize) -> sql::Result<()> {
+        let mut conn = self.conn.lock().unwrap();
+        let tx = conn.transaction()?;
+
+        tx.execute(
+            "DELETE FROM edges WHERE run = ?1 AND (source >= ?2 OR target >= ?2)",
+            params![run, from as i64],
+        )?;
+        tx.execute(
+            "DELETE FROM nodes WHERE run = ?1 AND id >= ?2",
+            params![run, from as i64],
+        )?;
+
+        tx.commit()
+    }
+}
@@ -0,0 +1,103 @@
+use std::{
+    collections::HashMap,
+    fs::File,
+    io::{self, BufRead, BufReader, Write},
+    path::Path,
+};
+
+/// First line of a link store file
+const HEADER: &str = "# wiki-path link store";
+
+/// Links of every article of a dump, as written by `ingest`.
+///
+/// The file has one tab-separated line per article, `L`, its title and the
+/// titles it links to in page order, and one per redirect, `R`, the redirect
+/// and its target. Titles are in URL form.
+pub struct LinkStore {
+    links: HashMap<String, Vec<String>>,
+    redirects: HashMap<String, String>,
+}
+
+impl LinkStore {
+    pub fn open(path: &Path) -> io::Result<Self> {
+        let mut store = LinkStore {
+            links: HashMap::new(),
+            redirects: HashMap::new(),
+        };
+
+        for (n, line) in BufReader::new(File::open(path)?).lines().enumerate() {
+            let line = line?;
+            if line.is_empty() || line.starts_with('#') {
+                continue;
+            }
+
+            let mut fields = line.split('\t');
+            match (fields.next(), fields.next()) {
+                (Some("L"), Some(title)) => {
+                    store
+                        .links
+                        .insert(title.to_string(), fields.map(str::to_string).collect());
+                }
+                (Some("R"), Some(from)) => {
+                    let to = fields.next().unwrap_or_default();
+                    store.redirects.insert(from.to_string(), to.to_string());
+                }
+                _ => {
+                    return Err(io::Error::new(
+                        io::ErrorKind::InvalidData,
+                        format!("line {}: not a link store entry", n + 1),
+                    ))
+                }
+            }
+        }
+
+        Ok(store)
+    }
+
+    /// Links of `title`, following a redirect
+    pub fn links(&self, title: &str) -> Option<&[String]> {
+        self.links.get(self.canonical(title)).map(Vec::as_slice)
+    }
+
+    /// The article `title` redirects to, or `title` itself
+    pub fn canonical<'a>(&'a self, title: &'a str) -> &'a str {
+        self.redirects.get(title).map_or(title, String::as_str)
+    }
+
+    /// The article at position `n`, wrapping around, in no particular order
+    pub fn random(&self, n: u64) -> Option<&str> {
+        if self.links.is_empty() {
+            return None;
+        }
+        let n = n as usize % self.links.len();
+        self.links.keys().nth(n).map(String::as_str)
+    }
+}
+
+/// Appends entries to a link store file
+pub struct Writer<W: Write> {
+    out: W,
+}
+
+impl<W: Write> Writer<W> {
+    pub fn new(mut out: W) -> io::Result<Self> {
+        writeln!(out, "{}", HEADER)?;
+        Ok(Writer { out })
+    }
+
+    pub fn article(&mut self, title: &str, links: &[String]) -> io::Result<()> {
+        write!(self.out, "L\t{}", title)?;
+        for link in links {
+            write!(self.out, "\t{}", link)?;
+        }
+        writeln!(self.out)
+    }
+
+    pub fn redirect(&mut self, from: &str, to: &str) -> io::Result<()> {
+        writeln!(self.out, "R\t{}\t{}", from, to)
+    }
+
+    pub fn finish(mut self) -> io::Result<()> {
+        self.out.flush()
+    }
+}
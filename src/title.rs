@@ -0,0 +1,344 @@
+use clap::ValueEnum;
+
+use crate::{
+    project::{self, Project},
+    wiki::{Error, Wiki},
+};
+
+/// Decode the percent-escapes of a title taken from a URL, e.g.
+/// `Caf%C3%A9` to `Café`
+pub fn decode(title: &str) -> String {
+    let bytes = title.as_bytes();
+    let mut out = Vec::with_capacity(bytes.len());
+
+    let mut i = 0;
+    while i < bytes.len() {
+        let hex = bytes
+            .get(i + 1..i + 3)
+            .and_then(|h| std::str::from_utf8(h).ok());
+        match (bytes[i], hex.and_then(|h| u8::from_str_radix(h, 16).ok())) {
+            (b'%', Some(b)) => {
+                out.push(b);
+                i += 3;
+            }
+            (b, _) => {
+                out.push(b);
+                i += 1;
+            }
+        }
+    }
+
+    String::from_utf8_lossy(&out).into_owned()
+}
+
+/// Turn an API title (`New York City`) into the form used in URLs
+/// (`New_York_City`)
+pub fn underscored(title: &str) -> String {
+    title.replace(' ', "_")
+}
+
+/// Percent-encode a title the way MediaWiki does in article URLs, so it
+/// compares equal to the names of extracted links
+pub fn encode(title: &str) -> String {
+    let mut out = String::with_capacity(title.len());
+    for b in title.bytes() {
+        match b {
+            b'A'..=b'Z' | b'a'..=b'z' | b'0'..=b'9' => out.push(b as char),
+            b'-' | b'_' | b'.' | b'~' | b';' | b':' | b'@' | b'$' | b'!' | b'*' | b'(' | b')'
+            | b',' | b'/' => out.push(b as char),
+            _ => out.push_str(&format!("%{:02X}", b)),
+        }
+    }
+    out
+}
+
+/// Bring a title in any of the forms users type or paste (spaces, escapes)
+/// into the URL form. Capitalization depends on the project, see
+/// `capitalize`.
+pub fn normalize(title: &str) -> String {
+    let decoded = decode(title).replace(' ', "_");
+
+    let mut words = decoded.split('_').filter(|w| !w.is_empty());
+    let mut joined = words.next().unwrap_or("").to_string();
+    for word in words {
+        joined.push('_');
+        joined.push_str(word);
+    }
+
+    encode(&joined)
+}
+
+/// Uppercase the first letter of a normalized title
+pub fn capitalize(title: &str) -> String {
+    let decoded = decode(title);
+    let mut chars = decoded.chars();
+    match chars.next() {
+        Some(first) => encode(&first.to_uppercase().chain(chars).collect::<String>()),
+        None => String::new(),
+    }
+}
+
+/// An article given on the command line
+#[derive(Clone, Debug, PartialEq)]
+pub enum Arg {
+    Title(String),
+    /// The title in an article URL, which has to be on the project searched
+    Url {
+        host: String,
+        title: String,
+    },
+    /// A Wikidata item ID, standing for the item's article on the project
+    Item(String),
+    /// The keyword `random`, standing for a random article
+    Random,
+}
+
+impl Arg {
+    /// Turn the argument into a title, picking the random article if needed
+    pub fn resolve(&self, wiki: &Wiki) -> Result<String, Error> {
+        match self {
+            Arg::Title(title) if wiki.project().capitalized() => Ok(capitalize(title)),
+            Arg::Title(title) => Ok(title.clone()),
+            Arg::Url { host, title } => {
+                check_host(host, wiki.project())?;
+                Arg::Title(title.clone()).resolve(wiki)
+            }
+            Arg::Item(id) => wiki.item_title(id),
+            Arg::Random => wiki.random(),
+        }
+    }
+}
+
+/// Make sure the host of an article URL is that of `project`, as the title
+/// is looked up there
+fn check_host(host: &str, project: Project) -> Result<(), String> {
+    if project.is_host(host) {
+        return Ok(());
+    }
+
+    let other = Project::value_variants()
+        .iter()
+        .find(|p| p.is_host(host))
+        .and_then(|p| p.to_possible_value());
+    let hint = match other {
+        Some(other) => format!("; search it with --project {}", other.get_name()),
+        None => ", nor any other project --project takes".to_string(),
+    };
+    Err(format!(
+        "{} is not {}, the wiki searched{}",
+        host,
+        project.host(),
+        hint
+    ))
+}
+
+/// Parse a category argument: `Category:Birds`, `Birds` or a category URL,
+/// kept with its prefix, in URL form
+pub fn parse_category(arg: &str) -> Result<Arg, String> {
+    let prefixed = |title: &str| {
+        let name = title.strip_prefix("Category:").unwrap_or(title);
+        format!("Category:{}", name)
+    };
+
+    match parse_arg(arg)? {
+        Arg::Title(title) => Ok(Arg::Title(prefixed(&title))),
+        Arg::Url { host, title } => Ok(Arg::Url {
+            host,
+            title: prefixed(&title),
+        }),
+        Arg::Item(_) | Arg::Random => Err(format!("{} is not a category", arg)),
+    }
+}
+
+/// Parse an article argument: a title (with spaces or underscores), an
+/// article URL, a Wikidata item ID or URL, or `random`
+pub fn parse_arg(arg: &str) -> Result<Arg, String> {
+    let mut arg = arg.trim();
+    for quote in ['"', '\''] {
+        if arg.len() >= 2 && arg.starts_with(quote) && arg.ends_with(quote) {
+            arg = &arg[1..arg.len() - 1];
+        }
+    }
+
+    if arg == "random" {
+        return Ok(Arg::Random);
+    }
+    if let Some(id) = item_id(arg) {
+        return Ok(Arg::Item(id));
+    }
+
+    let (host, title) = url_title(arg)?.unwrap_or((None, arg));
+    let title = normalize(title);
+    if title.is_empty() {
+        return Err("the article title is empty".to_string());
+    }
+
+    Ok(match host {
+        Some(host) => Arg::Url {
+            host: host.to_ascii_lowercase(),
+            title,
+        },
+        None => Arg::Title(title),
+    })
+}
+
+/// The Wikidata item ID `arg` is or links to, e.g. `Q937` or
+/// `https://www.wikidata.org/wiki/Q937`
+fn item_id(arg: &str) -> Option<String> {
+    let rest = ["https://", "http://", "//"]
+        .iter()
+        .find_map(|scheme| arg.strip_prefix(scheme));
+    let id = match rest {
+        Some(rest) => ["www.wikidata.org/wiki/", "m.wikidata.org/wiki/"]
+            .iter()
+            .find_map(|prefix| rest.strip_prefix(prefix))?
+            .split(['?', '#'])
+            .next()?,
+        None => arg,
+    };
+
+    let digits = id.strip_prefix(['Q', 'q'])?;
+    if digits.is_empty() || !digits.bytes().all(|b| b.is_ascii_digit()) {
+        return None;
+    }
+    Some(format!("Q{}", digits))
+}
+
+/// The host and title in an article URL, with no host for a path on the
+/// wiki searched, or `None` if `arg` isn't a URL
+fn url_title(arg: &str) -> Result<Option<(Option<&str>, &str)>, String> {
+    let rest = ["https://", "http://", "//"]
+        .iter()
+        .find_map(|scheme| arg.strip_prefix(scheme));
+
+    let (host, path) = match rest {
+        Some(rest) => {
+            let (host, path) = rest.split_once('/').unwrap_or((rest, ""));
+            if !project::is_project_host(host) {
+                return Err(format!("{} is not the address of a supported wiki", host));
+            }
+            (Some(host), path)
+        }
+        None if arg.starts_with("/wiki/") => (None, &arg[1..]),
+        None => return Ok(None),
+    };
+
+    let title = if let Some(title) = path.strip_prefix("wiki/") {
+        title.split(['?', '#']).next().unwrap_or("")
+    } else if let Some(query) = path.strip_prefix("w/index.php?") {
+        query
+            .split('&')
+            .find_map(|param| param.strip_prefix("title="))
+            .unwrap_or("")
+    } else {
+        return Err(format!("{} is not an article URL", arg));
+    };
+
+    Ok(Some((host, title.split('#').next().unwrap_or(""))))
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    fn title(arg: &str) -> String {
+        match parse_arg(arg) {
+            Ok(Arg::Title(title) | Arg::Url { title, .. }) => title,
+            other => panic!("{} parsed as {:?}", arg, other),
+        }
+    }
+
+    #[test]
+    fn titles_take_spaces_or_underscores() {
+        assert_eq!(title("Adolf Hitler"), "Adolf_Hitler");
+        assert_eq!(title("Adolf_Hitler"), "Adolf_Hitler");
+        assert_eq!(title("  \"New  York City\" "), "New_York_City");
+        assert_eq!(title("'Café'"), "Caf%C3%A9");
+    }
+
+    #[test]
+    fn article_urls_give_their_title() {
+        assert_eq!(title("https://en.wikipedia.org/wiki/Hamburg"), "Hamburg");
+        assert_eq!(title("//en.m.wikipedia.org/wiki/K%C3%B6ln"), "K%C3%B6ln");
+        assert_eq!(title("/wiki/Teletubbies"), "Teletubbies");
+        assert_eq!(
+            title("https://en.wikipedia.org/w/index.php?title=Hamburg&oldid=1"),
+            "Hamburg"
+        );
+    }
+
+    #[test]
+    fn urls_must_be_on_the_wiki_searched() {
+        let url = |host: &str| Arg::Url {
+            host: host.to_string(),
+            title: "Hamburg".to_string(),
+        };
+        assert_eq!(
+            parse_arg("https://EN.wikipedia.org/wiki/Hamburg"),
+            Ok(url("en.wikipedia.org"))
+        );
+        assert_eq!(
+            parse_arg("/wiki/Hamburg"),
+            Ok(Arg::Title("Hamburg".to_string()))
+        );
+
+        assert!(check_host("en.wikipedia.org", Project::Wikipedia).is_ok());
+        assert!(check_host("en.m.wikipedia.org", Project::Wikipedia).is_ok());
+        let err = check_host("de.wikipedia.org", Project::Wikipedia).unwrap_err();
+        assert!(
+            err.ends_with("nor any other project --project takes"),
+            "{}",
+            err
+        );
+        let err = check_host("simple.wikipedia.org", Project::Wikipedia).unwrap_err();
+        assert!(err.ends_with("--project simple"), "{}", err);
+    }
+
+    #[test]
+    fn fragments_and_queries_are_dropped() {
+        assert_eq!(
+            title("https://en.wikipedia.org/wiki/Hamburg#History"),
+            "Hamburg"
+        );
+        assert_eq!(
+            title("https://en.wikipedia.org/wiki/Hamburg?useskin=vector"),
+            "Hamburg"
+        );
+        assert_eq!(
+            title("https://en.wikipedia.org/w/index.php?action=view&title=Hamburg#Name"),
+            "Hamburg"
+        );
+    }
+
+    #[test]
+    fn other_urls_are_rejected() {
+        assert!(parse_arg("https://example.com/wiki/Hamburg").is_err());
+        assert!(parse_arg("https://en.wikipedia.org/Hamburg").is_err());
+        assert!(parse_arg("https://en.wikipedia.org/wiki/").is_err());
+        assert!(parse_arg("\"\"").is_err());
+    }
+
+    #[test]
+    fn wikidata_items_are_ids_or_urls() {
+        let item = |id: &str| Ok(Arg::Item(id.to_string()));
+        assert_eq!(parse_arg("Q937"), item("Q937"));
+        assert_eq!(parse_arg("q352"), item("Q352"));
+        assert_eq!(
+            parse_arg("https://www.wikidata.org/wiki/Q937"),
+            item("Q937")
+        );
+        assert_eq!(
+            parse_arg("//m.wikidata.org/wiki/Q937#sitelinks"),
+            item("Q937")
+        );
+        assert_eq!(title("Q"), "Q");
+        assert_eq!(title("Q1a"), "Q1a");
+    }
+
+    #[test]
+    fn random_is_a_keyword() {
+        assert_eq!(parse_arg("random"), Ok(Arg::Random));
+        assert_eq!(parse_arg(" 'random' "), Ok(Arg::Random));
+        assert_eq!(title("Random"), "Random");
+    }
+}
@@ -0,0 +1,70 @@
+use std::{fs, net::SocketAddr, path::PathBuf, sync::Arc, time::Duration};
+
+use reqwest as rw;
+
+use crate::{
+    dns::{IpFamily, Resolver},
+    wiki::Error,
+};
+
+/// Settings of the HTTP client all requests go through
+#[derive(Debug, Default)]
+pub struct Transport {
+    /// PEM file of extra certificate authorities to trust, such as that of a
+    /// TLS-intercepting proxy
+    pub ca_bundle: Option<PathBuf>,
+    /// Accept any certificate
+    pub insecure: bool,
+    /// Give up connecting after this long
+    pub connect_timeout: Option<Duration>,
+    /// Give up on a request, from connecting to the end of the body, after
+    /// this long
+    pub timeout: Option<Duration>,
+    /// Address family to try first
+    pub prefer: Option<IpFamily>,
+    /// Resolve host names by asking this server instead of the system
+    pub dns_server: Option<SocketAddr>,
+}
+
+impl Transport {
+    pub fn client(&self) -> Result<rw::blocking::Client, Error> {
+        let mut builder = rw::blocking::Client::builder();
+
+        if let Some(path) = &self.ca_bundle {
+            let pem = fs::read(path).map_err(|err| format!("{}: {}", path.display(), err))?;
+            for cert in rw::Certificate::from_pem_bundle(&pem)? {
+                builder = builder.add_root_certificate(cert);
+            }
+        }
+        if self.insecure {
+            builder = builder.danger_accept_invalid_certs(true);
+        }
+        if let Some(timeout) = self.connect_timeout {
+            builder = builder.connect_timeout(timeout);
+        }
+        // Otherwise the 30 seconds the blocking client defaults to
+        if let Some(timeout) = self.timeout {
+            builder = builder.timeout(timeout);
+        }
+
+        // Preferring a family rather than binding to it keeps the other as a
+        // fallback
+        if self.prefer.is_some() || self.dns_server.is_some() {
+            builder = builder.dns_resolver(Arc::new(Resolver {
+                server: self.dns_server,
+                prefer: self.prefer,
+            }));
+        }
+
+        Ok(builder.build()?)
+    }
+}
+
+/// Parse a number of seconds, possibly fractional
+pub fn parse_secs(arg: &str) -> Result<Duration, String> {
+    arg.parse::<f64>()
+        .ok()
+        .and_then(|secs| Duration::try_from_secs_f64(secs).ok())
+        .filter(|d| !d.is_zero())
+        .ok_or_else(|| format!("{} is not a positive number of seconds", arg))
+}
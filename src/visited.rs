@@ -0,0 +1,98 @@
+use std::{
+    collections::HashMap,
+    fs::File,
+    io::{self, BufRead, BufReader, BufWriter, Write},
+    path::Path,
+};
+
+use crate::search::Explored;
+
+/// First line of a visited set file
+const HEADER: &str = "# wiki-path visited set";
+
+/// Write the articles a breadth-first search discovered to `path`, for
+/// `load` to pick up from later.
+///
+/// After the header come the numbers of articles processed and expanded,
+/// then one tab-separated line per article in discovery order: its title,
+/// depth and the line number of its parent, 0 for the start article.
+pub fn save(path: &Path, ex: &Explored) -> io::Result<()> {
+    let mut w = BufWriter::new(File::create(path)?);
+
+    writeln!(w, "{}", HEADER)?;
+    writeln!(w, "# processed {}", ex.processed)?;
+    writeln!(w, "# expanded {}", ex.expanded)?;
+    for idx in 1..ex.articles.len() {
+        writeln!(
+            w,
+            "{}\t{}\t{}",
+            ex.articles[idx], ex.depth[idx], ex.parent[idx]
+        )?;
+    }
+
+    w.flush()
+}
+
+/// Read a visited set written by `save`
+pub fn load(path: &Path) -> io::Result<Explored> {
+    let invalid = |n: usize, what: &str| {
+        io::Error::new(
+            io::ErrorKind::InvalidData,
+            format!("line {}: {}", n + 1, what),
+        )
+    };
+
+    let mut ex = Explored {
+        articles: vec![String::new()],
+        parent: vec![0],
+        other_parents: HashMap::new(),
+        depth: vec![0],
+        edges: Vec::new(),
+        expanded: 0,
+        found: false,
+        ends: Vec::new(),
+        processed: 0,
+        levels: Vec::new(),
+    };
+
+    for (n, line) in BufReader::new(File::open(path)?).lines().enumerate() {
+        let line = line?;
+        if n == 0 && line != HEADER {
+            return Err(invalid(n, "not a visited set"));
+        }
+        if let Some(comment) = line.strip_prefix("# ") {
+            let count = |prefix| comment.strip_prefix(prefix).and_then(|c| c.parse().ok());
+            if let Some(processed) = count("processed ") {
+                ex.processed = processed;
+            } else if let Some(expanded) = count("expanded ") {
+                ex.expanded = expanded;
+            }
+            continue;
+        }
+
+        let fields: Vec<&str> = line.split('\t').collect();
+        let [title, depth, parent] = fields[..] else {
+            return Err(invalid(n, "expected a title, depth and parent"));
+        };
+        let (Ok(depth), Ok(parent)) = (depth.parse(), parent.parse()) else {
+            return Err(invalid(n, "expected a title, depth and parent"));
+        };
+        if parent >= ex.articles.len() {
+            return Err(invalid(n, "the parent comes after the article"));
+        }
+
+        ex.articles.push(title.to_string());
+        ex.depth.push(depth);
+        ex.parent.push(parent);
+    }
+
+    if ex.articles.len() < 2 {
+        return Err(io::Error::new(
+            io::ErrorKind::InvalidData,
+            "the visited set is empty",
+        ));
+    }
+    ex.processed = ex.processed.min(ex.articles.len() - 1);
+
+    Ok(ex)
+}
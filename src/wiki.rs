@@ -0,0 +1,1113 @@
+use std::{
+    cell::Cell,
+    collections::{HashMap, HashSet},
+    error, fmt,
+    fs::File,
+    hash::{BuildHasher, RandomState},
+    io::{self, LineWriter, Write},
+    path::Path,
+    sync::{Arc, Mutex},
+    time::{Duration, Instant},
+};
+
+use reqwest as rw;
+use scraper as sc;
+use serde_json::{json, Value};
+
+use crate::{
+    audit::Audit,
+    auth,
+    congestion::{Controller, Ticket, MAX_IN_FLIGHT},
+    project::Project,
+    quota::Quota,
+    store::LinkStore,
+    title,
+    zim::Zim,
+};
+
+const REQ_WAIT_SECS: f32 = 0.5;
+/// Requests allowed per hour across every run, what a single run waiting
+/// `REQ_WAIT_SECS` between requests would make
+const REQS_PER_HOUR: u32 = (3600.0 / REQ_WAIT_SECS) as u32;
+
+/// Requests a personal API token may make to the API Portal per hour
+const PORTAL_REQS_PER_HOUR: u32 = 5000;
+
+const WIKIDATA_API: &str = "https://www.wikidata.org/w/api.php";
+
+pub type Error = Box<dyn error::Error + Send + Sync>;
+
+/// Cached links of an article, locked while they are fetched, `None` until
+/// they are
+type Slot = Arc<Mutex<Option<Vec<String>>>>;
+
+/// The page an article link points to doesn't exist
+#[derive(Debug)]
+pub struct Missing(pub String);
+
+impl fmt::Display for Missing {
+    fn fmt(&self, f: &mut fmt::Formatter) -> fmt::Result {
+        write!(f, "no article named {}", self.0)
+    }
+}
+
+impl error::Error for Missing {}
+
+/// Wall-clock time spent in each phase of fetching articles. Time a phase
+/// goes on in several threads at once counts once.
+#[derive(Clone, Copy, Debug, Default)]
+pub struct Timings {
+    pub requests: u32,
+    pub waiting: Duration,
+    pub network: Duration,
+    pub parsing: Duration,
+    /// Time any thread was in one of the phases
+    pub busy: Duration,
+    /// Links to pages that turned out not to exist
+    pub dead_ends: u32,
+}
+
+#[derive(Clone, Copy)]
+enum Phase {
+    Waiting,
+    Network,
+    Parsing,
+}
+
+/// Threads in each phase, then in any, and since when they have been
+#[derive(Default)]
+struct Clock {
+    threads: [usize; 4],
+    since: [Option<Instant>; 4],
+}
+
+const ANY_PHASE: usize = 3;
+
+thread_local! {
+    /// Requests sent by this thread, to tell whose they are when several
+    /// threads fetch at once
+    static SENT: Cell<u32> = const { Cell::new(0) };
+}
+
+/// Requests the calling thread has sent so far, with any wiki
+pub fn thread_requests() -> u32 {
+    SENT.with(Cell::get)
+}
+
+impl Timings {
+    pub fn print(&self, total: Duration) {
+        let fmt = |d: Duration| jiff::SignedDuration::from_secs_f64(d.as_secs_f64());
+
+        eprintln!("Requests: {}", self.requests);
+        eprintln!("Waiting: {:#}", fmt(self.waiting));
+        eprintln!("Network: {:#}", fmt(self.network));
+        eprintln!("Parsing: {:#}", fmt(self.parsing));
+        eprintln!("Other: {:#}", fmt(total.saturating_sub(self.busy)));
+        eprintln!("Dead ends: {}", self.dead_ends);
+    }
+}
+
+/// Local copy of the wiki searches can run on
+pub enum Offline {
+    Zim(Zim),
+    Store(LinkStore),
+}
+
+/// A way of sending requests, with a rate limit of its own
+struct Lane {
+    /// Spaces requests out and decides how many may be in flight at once
+    pacing: Controller,
+    /// Requests made by other runs, shared through the cache directory
+    quota: Option<Quota>,
+    /// Personal API token sent with every request
+    token: Option<String>,
+}
+
+impl Lane {
+    fn new(req_wait: Duration, quota: Option<Quota>, token: Option<String>) -> Self {
+        Lane {
+            pacing: Controller::new(req_wait),
+            quota,
+            token,
+        }
+    }
+
+    fn req_wait(&self) -> Duration {
+        self.pacing.gap()
+    }
+
+    /// When the rate limit lets the next request go
+    fn next_free(&self) -> Instant {
+        self.pacing.next_free()
+    }
+
+    /// Wait for the rate limit and the quota. The ticket is to be released
+    /// once the response is in.
+    fn wait(&self) -> Ticket {
+        let mut ticket = self.pacing.acquire();
+        if let Some(quota) = &self.quota {
+            match quota.acquire() {
+                Ok(waited) => {
+                    ticket.wait += waited;
+                    ticket.sent = Instant::now();
+                }
+                Err(err) => eprintln!("Request quota: {}", err),
+            }
+        }
+        ticket
+    }
+}
+
+/// Rate-limited access to the articles of the wiki.
+///
+/// A `Wiki` can be shared between threads searching at the same time; they
+/// then share its HTTP client, rate limit and link cache.
+pub struct Wiki {
+    project: Project,
+    client: rw::blocking::Client,
+    lane: Lane,
+    /// Authenticated lane to the API Portal's REST API, taking turns with
+    /// `lane` at fetching articles
+    rest: Option<Lane>,
+    /// Links of every article fetched or being fetched, when caching is
+    /// enabled
+    cache: Option<Mutex<HashMap<String, Slot>>>,
+    request_log: Mutex<Option<LineWriter<File>>>,
+    timings: Mutex<Timings>,
+    clock: Mutex<Clock>,
+    /// Local source articles are read from instead of the network
+    offline: Option<Offline>,
+    /// Only take links from the lead section
+    lead_only: bool,
+    /// Namespaces whose pages are followed like articles
+    namespaces: Vec<String>,
+    /// Origin requests go to instead of the project's site
+    base: Option<String>,
+    audit: Option<Audit>,
+}
+
+impl Wiki {
+    pub fn new(project: Project, client: rw::blocking::Client) -> Self {
+        let req_wait = Duration::from_secs_f32(REQ_WAIT_SECS);
+        let quota = Quota::open("anonymous", REQS_PER_HOUR, req_wait);
+
+        Wiki {
+            project,
+            client,
+            lane: Lane::new(req_wait, quota, None),
+            rest: None,
+            cache: None,
+            request_log: Mutex::new(None),
+            timings: Mutex::new(Timings::default()),
+            clock: Mutex::new(Clock::default()),
+            offline: None,
+            lead_only: false,
+            namespaces: Vec::new(),
+            base: None,
+            audit: None,
+        }
+    }
+
+    pub fn project(&self) -> Project {
+        self.project
+    }
+
+    /// Keep the links of fetched articles in memory, so searches run one
+    /// after the other don't fetch the same article twice
+    pub fn enable_cache(&mut self) {
+        self.cache.get_or_insert_with(|| Mutex::new(HashMap::new()));
+    }
+
+    /// Read articles from a local source instead of the live wiki. The
+    /// Action API isn't available then.
+    pub fn go_offline(&mut self, source: Offline) {
+        self.offline = Some(source);
+    }
+
+    /// Authenticate requests with a personal API token. Its requests count
+    /// against a quota of their own.
+    pub fn set_token(&mut self, token: String) {
+        let req_wait = self.lane.req_wait();
+        self.lane.quota = Quota::open(&auth::account(&token), REQS_PER_HOUR, req_wait);
+        self.lane.token = Some(token);
+    }
+
+    /// Fetch articles over two lanes with rate limits of their own, taking
+    /// whichever is free first: anonymous requests for pages, and requests
+    /// to the API Portal's REST API authenticated with the token set
+    /// before, within its hourly limit. Other requests stay anonymous.
+    pub fn split_lanes(&mut self) -> Result<(), Error> {
+        let Some(token) = self.lane.token.take() else {
+            return Err("two lanes need a personal API token, see `wiki-path auth login`".into());
+        };
+
+        let req_wait = self.lane.req_wait();
+        self.lane.quota = Quota::open("anonymous", REQS_PER_HOUR, req_wait);
+
+        let portal_wait = Duration::from_secs_f64(3600.0 / PORTAL_REQS_PER_HOUR as f64);
+        let account = format!("{}-portal", auth::account(&token));
+        let quota = Quota::open(&account, PORTAL_REQS_PER_HOUR, portal_wait);
+        self.rest = Some(Lane::new(portal_wait, quota, Some(token)));
+        Ok(())
+    }
+
+    /// Send requests to `base`, e.g. `http://127.0.0.1:8080`, instead of the
+    /// project's site. The server is taken to be local, so neither the rate
+    /// limit nor the shared quota apply.
+    pub fn serve_from(&mut self, base: String) {
+        self.base = Some(base);
+        self.lane.pacing = Controller::new(Duration::ZERO);
+        self.lane.quota = None;
+    }
+
+    /// Only take the links of articles' lead sections, before the first
+    /// heading. Doesn't apply to link stores, which hold whole articles.
+    pub fn set_lead_only(&mut self, lead_only: bool) {
+        self.lead_only = lead_only;
+    }
+
+    /// Trace requests, link extraction and the searches run on the wiki to
+    /// `path`, see `Audit`
+    pub fn audit_to(&mut self, path: &Path) -> io::Result<()> {
+        self.audit = Some(Audit::create(path)?);
+        Ok(())
+    }
+
+    /// Add a record to the audit log, if there is one. `data` is only built
+    /// then.
+    pub fn audit(&self, kind: &str, data: impl FnOnce() -> Value) {
+        if let Some(audit) = &self.audit {
+            audit.record(kind, data());
+        }
+    }
+
+    /// Requests made and time spent so far
+    pub fn timings(&self) -> Timings {
+        *self.timings.lock().unwrap()
+    }
+
+    /// Run `f` as part of `phase`, counting the wall-clock time it takes
+    /// together with that of other threads in the phase meanwhile
+    fn timed<T>(&self, phase: Phase, f: impl FnOnce() -> T) -> T {
+        self.clock_phase(phase, true);
+        let result = f();
+        self.clock_phase(phase, false);
+        result
+    }
+
+    fn clock_phase(&self, phase: Phase, entering: bool) {
+        let now = Instant::now();
+        let mut clock = self.clock.lock().unwrap();
+        let mut timings = self.timings.lock().unwrap();
+
+        for slot in [phase as usize, ANY_PHASE] {
+            if entering {
+                if clock.threads[slot] == 0 {
+                    clock.since[slot] = Some(now);
+                }
+                clock.threads[slot] += 1;
+                continue;
+            }
+
+            clock.threads[slot] -= 1;
+            if clock.threads[slot] > 0 {
+                continue;
+            }
+            let spent = clock.since[slot]
+                .take()
+                .map_or(Duration::ZERO, |since| now - since);
+            match slot {
+                0 => timings.waiting += spent,
+                1 => timings.network += spent,
+                2 => timings.parsing += spent,
+                _ => timings.busy += spent,
+            }
+        }
+    }
+
+    /// Return the names of the articles `article` links to, in page order
+    pub fn links(&self, article: &str) -> Result<Vec<String>, Error> {
+        let Some(cache) = &self.cache else {
+            return self.fetch_links(article);
+        };
+
+        // The slot stays locked while its article is fetched, so a search
+        // asking for the same article meanwhile waits for the result instead
+        // of fetching it again
+        let slot = cache
+            .lock()
+            .unwrap()
+            .entry(article.to_string())
+            .or_default()
+            .clone();
+        let mut slot = slot.lock().unwrap();
+        if let Some(links) = &*slot {
+            self.audit(
+                "cached",
+                || json!({ "article": article, "links": links.len() }),
+            );
+            return Ok(links.clone());
+        }
+
+        // On error the slot stays empty and the next search tries again
+        let links = self.fetch_links(article)?;
+        *slot = Some(links.clone());
+
+        Ok(links)
+    }
+
+    /// Links of `article` if they were fetched with the cache enabled
+    pub fn cached_links(&self, article: &str) -> Option<Vec<String>> {
+        let slot = self.cache.as_ref()?.lock().unwrap().get(article)?.clone();
+        let links = slot.lock().unwrap().clone();
+        links
+    }
+
+    fn fetch_links(&self, article: &str) -> Result<Vec<String>, Error> {
+        match &self.offline {
+            Some(Offline::Zim(zim)) => return self.zim_links(zim, article),
+            Some(Offline::Store(store)) => {
+                return match store.links(article) {
+                    Some(links) => {
+                        self.audit(
+                            "stored",
+                            || json!({ "article": article, "links": links.len() }),
+                        );
+                        Ok(links.to_vec())
+                    }
+                    None => Err(self.missing(article)),
+                }
+            }
+            None => {}
+        }
+
+        let (body, relative) = self.fetch(article)?;
+
+        let document = self.timed(Phase::Parsing, || {
+            sc::Html::parse_document(self.section(article, &body))
+        });
+
+        if relative {
+            return Ok(relative_links(self.project, &document, |name| {
+                let decision = self.link_decision(article, name);
+                self.audit(
+                    "extract",
+                    || json!({ "article": article, "href": name, "decision": decision }),
+                );
+                decision == "kept"
+            }));
+        }
+
+        let selector = sc::Selector::parse("a[href]").unwrap();
+
+        let mut links = Vec::new();
+        for element in document.select(&selector) {
+            let Some(href) = element.value().attr("href") else {
+                continue;
+            };
+            // Exclude "Main_Page" or Special: / Talk: etc, and links to
+            // sections of the article itself
+            let decision = match self.project.link_name(href) {
+                None => "not_a_wiki_link",
+                Some(name) => {
+                    let decision = self.link_decision(article, name);
+                    if decision == "kept" {
+                        links.push(name.to_string());
+                    }
+                    decision
+                }
+            };
+            self.audit(
+                "extract",
+                || json!({ "article": article, "href": href, "decision": decision }),
+            );
+        }
+
+        Ok(links)
+    }
+
+    /// Also follow links to pages in `namespaces`, e.g. `Category`
+    pub fn include_namespaces(&mut self, namespaces: Vec<String>) {
+        self.namespaces = namespaces;
+    }
+
+    /// Whether the link to `name` is followed: articles, and pages in the
+    /// included namespaces
+    fn follows(&self, name: &str) -> bool {
+        self.project.is_article(name)
+            || name.split_once(':').is_some_and(|(prefix, _)| {
+                let prefix = title::decode(prefix).replace(' ', "_");
+                self.namespaces
+                    .iter()
+                    .any(|ns| ns.replace(' ', "_").eq_ignore_ascii_case(&prefix))
+            })
+    }
+
+    /// Whether the link from `article` to `name` is kept, or why not: the
+    /// same for pages from the wiki and from archives
+    fn link_decision(&self, article: &str, name: &str) -> &'static str {
+        if !self.follows(name) {
+            "namespace"
+        } else if name == article {
+            "self_link"
+        } else {
+            "kept"
+        }
+    }
+
+    /// The part of the body of `article` links are taken from
+    fn section<'a>(&self, article: &str, body: &'a str) -> &'a str {
+        let (cut, section) = if self.lead_only {
+            ("lead_only", lead(body))
+        } else {
+            ("content_end", content(body))
+        };
+        if section.len() < body.len() {
+            self.audit("section", || {
+                json!({
+                    "article": article,
+                    "cut": cut,
+                    "kept_bytes": section.len(),
+                    "total_bytes": body.len(),
+                })
+            });
+        }
+        section
+    }
+
+    /// Links of an article stored in a ZIM archive
+    fn zim_links(&self, zim: &Zim, article: &str) -> Result<Vec<String>, Error> {
+        let Some(body) = zim.article(&title::decode(article))? else {
+            return Err(self.missing(article));
+        };
+
+        let document = self.timed(Phase::Parsing, || {
+            sc::Html::parse_document(self.section(article, &body))
+        });
+
+        Ok(relative_links(self.project, &document, |name| {
+            let decision = self.link_decision(article, name);
+            self.audit(
+                "extract",
+                || json!({ "article": article, "href": name, "decision": decision }),
+            );
+            decision == "kept"
+        }))
+    }
+
+    /// Title of a random article, as picked by Special:Random
+    pub fn random(&self) -> Result<String, Error> {
+        let hasher = RandomState::new();
+        match &self.offline {
+            Some(Offline::Zim(zim)) => {
+                // Most entries are articles, so a few tries are enough
+                for attempt in 0..100 {
+                    if let Some(url) = zim.article_url(hasher.hash_one(attempt) as u32)? {
+                        return Ok(title::encode(&url));
+                    }
+                }
+                return Err("no article found in the archive".into());
+            }
+            Some(Offline::Store(store)) => {
+                return match store.random(hasher.hash_one(0)) {
+                    Some(title) => Ok(title.to_string()),
+                    None => Err("the link store is empty".into()),
+                }
+            }
+            None => {}
+        }
+
+        let res = self.get("Special:Random")?;
+
+        match res.url.path().strip_prefix("/wiki/") {
+            Some(title) => Ok(title.to_string()),
+            None => Err(format!("unexpected random article URL {}", res.url).into()),
+        }
+    }
+
+    /// Fetch the HTML of `article` over whichever lane is free first, and
+    /// tell whether its links are relative (`./Title`), as in pages of the
+    /// REST API
+    fn fetch(&self, article: &str) -> Result<(String, bool), Error> {
+        let (res, relative) = match &self.rest {
+            Some(rest) if rest.next_free() < self.lane.next_free() => {
+                let request = self.client.get(self.project.portal_page_url(article));
+                (self.send(rest, request)?, true)
+            }
+            _ => (self.get(article)?, false),
+        };
+
+        // Don't parse the error page
+        if res.status == rw::StatusCode::NOT_FOUND {
+            return Err(self.missing(article));
+        }
+        if !res.status.is_success() {
+            return Err(format!("{}: HTTP {}", article, res.status).into());
+        }
+
+        Ok((res.body, relative))
+    }
+
+    /// Count a link to `article` as a dead end
+    fn missing(&self, article: &str) -> Error {
+        self.timings.lock().unwrap().dead_ends += 1;
+        Box::new(Missing(article.to_string()))
+    }
+
+    /// Query the MediaWiki Action API, returning the parsed JSON response
+    pub fn api(&self, params: &[(&str, &str)]) -> Result<Value, Error> {
+        if self.offline.is_some() {
+            return Err("the MediaWiki API isn't available offline".into());
+        }
+
+        let request = self
+            .client
+            .get(match &self.base {
+                Some(base) => format!("{}/w/api.php", base),
+                None => self.project.api_url(),
+            })
+            .query(&[("format", "json"), ("formatversion", "2")])
+            .query(params);
+        let res = self.send(&self.lane, request)?;
+
+        let value: Value = serde_json::from_str(&res.body)?;
+        if let Some(info) = value["error"]["info"].as_str() {
+            return Err(info.into());
+        }
+
+        Ok(value)
+    }
+
+    /// Title of the project's article about the Wikidata item `id`, e.g.
+    /// `Q937`
+    pub fn item_title(&self, id: &str) -> Result<String, Error> {
+        if self.offline.is_some() || self.base.is_some() {
+            return Err("Wikidata items can't be looked up offline".into());
+        }
+        let Some(site) = self.project.wikidata_site() else {
+            return Err(
+                format!("Wikidata items don't link to {} pages", self.project.host()).into(),
+            );
+        };
+
+        let request = self.client.get(WIKIDATA_API).query(&[
+            ("action", "wbgetentities"),
+            ("format", "json"),
+            ("ids", id),
+            ("props", "sitelinks"),
+            ("sitefilter", site),
+        ]);
+        let res = self.send(&self.lane, request)?;
+
+        let value: Value = serde_json::from_str(&res.body)?;
+        if let Some(info) = value["error"]["info"].as_str() {
+            return Err(format!("{}: {}", id, info).into());
+        }
+        let entity = &value["entities"][id];
+        if entity.get("missing").is_some() {
+            return Err(format!("no Wikidata item {}", id).into());
+        }
+
+        match entity["sitelinks"][site]["title"].as_str() {
+            Some(t) => Ok(title::normalize(t)),
+            None => Err(format!("{} has no article on {}", id, self.project.host()).into()),
+        }
+    }
+
+    /// Whether `article` links to `other`, both in URL form, without
+    /// fetching the article online: the API is asked. Offline, where it
+    /// costs nothing, its links are read.
+    pub fn links_to(&self, article: &str, other: &str) -> Result<bool, Error> {
+        if self.offline.is_some() {
+            return Ok(self.links(article)?.iter().any(|link| link == other));
+        }
+
+        let res = self.api(&[
+            ("action", "query"),
+            ("titles", &title::decode(article)),
+            ("redirects", "1"),
+            ("prop", "links"),
+            ("pltitles", &title::decode(other)),
+        ])?;
+
+        Ok(res["query"]["pages"]
+            .as_array()
+            .into_iter()
+            .flatten()
+            .any(|page| page["links"].as_array().is_some_and(|l| !l.is_empty())))
+    }
+
+    /// Canonical titles of `titles`, in the same order, following
+    /// normalization and redirects
+    pub fn canonical(&self, titles: &[&str]) -> Result<Vec<String>, Error> {
+        let decoded: Vec<String> = titles.iter().map(|t| title::decode(t)).collect();
+        match &self.offline {
+            Some(Offline::Zim(zim)) => {
+                return decoded
+                    .into_iter()
+                    .map(|t| Ok(zim.canonical(&t)?.unwrap_or(t)))
+                    .collect();
+            }
+            Some(Offline::Store(store)) => {
+                return Ok(titles
+                    .iter()
+                    .map(|t| title::decode(store.canonical(t)))
+                    .collect());
+            }
+            None => {}
+        }
+
+        let res = self.api(&[
+            ("action", "query"),
+            ("titles", &decoded.join("|")),
+            ("redirects", "1"),
+        ])?;
+
+        let lookup = |key: &str, from: &str| {
+            res["query"][key]
+                .as_array()
+                .into_iter()
+                .flatten()
+                .find(|m| m["from"] == from)
+                .and_then(|m| m["to"].as_str())
+                .map(str::to_string)
+        };
+
+        Ok(decoded
+            .iter()
+            .map(|t| {
+                let normalized = lookup("normalized", t).unwrap_or_else(|| t.clone());
+                let canonical = lookup("redirects", &normalized).unwrap_or(normalized);
+                title::underscored(&canonical)
+            })
+            .collect())
+    }
+
+    /// Resolve `title` to the article it redirects to, if any, and list the
+    /// redirects to that article. Titles are returned with underscores.
+    pub fn redirects(&self, title: &str) -> Result<(String, Vec<String>), Error> {
+        let title = title::decode(title);
+        let res = self.api(&[
+            ("action", "query"),
+            ("titles", &title),
+            ("redirects", "1"),
+            ("prop", "redirects"),
+            ("rdnamespace", "0"),
+            ("rdlimit", "max"),
+        ])?;
+
+        let page = &res["query"]["pages"][0];
+        if page["missing"].as_bool().unwrap_or(false) {
+            return Err(format!("no article named {}", title).into());
+        }
+        let Some(canonical) = page["title"].as_str() else {
+            return Err(format!("unexpected API response for {}", title).into());
+        };
+
+        let redirects = page["redirects"]
+            .as_array()
+            .into_iter()
+            .flatten()
+            .filter_map(|r| r["title"].as_str())
+            .map(title::underscored)
+            .collect();
+
+        Ok((title::underscored(canonical), redirects))
+    }
+
+    /// Up to 500 articles linking to `title`, in URL form. Redirects to it
+    /// aren't followed.
+    pub fn backlinks(&self, title: &str) -> Result<Vec<String>, Error> {
+        let title = title::decode(title);
+        let res = self.api(&[
+            ("action", "query"),
+            ("list", "backlinks"),
+            ("bltitle", &title),
+            ("blnamespace", "0"),
+            ("blfilterredir", "nonredirects"),
+            ("bllimit", "max"),
+        ])?;
+
+        let Some(backlinks) = res["query"]["backlinks"].as_array() else {
+            return Err(format!("unexpected API response for {}", title).into());
+        };
+
+        Ok(backlinks
+            .iter()
+            .filter_map(|b| b["title"].as_str())
+            .map(|t| title::encode(&title::underscored(t)))
+            .collect())
+    }
+
+    /// Query the Action API like `api`, following `continue` until every
+    /// batch of results has been passed to `on_batch`
+    pub fn api_continued(
+        &self,
+        params: &[(&str, &str)],
+        mut on_batch: impl FnMut(&Value) -> Result<(), Error>,
+    ) -> Result<(), Error> {
+        let mut cont: Vec<(String, String)> = Vec::new();
+        loop {
+            let mut all = params.to_vec();
+            all.extend(cont.iter().map(|(k, v)| (k.as_str(), v.as_str())));
+            let res = self.api(&all)?;
+            on_batch(&res)?;
+
+            match res["continue"].as_object() {
+                Some(next) => {
+                    cont = next
+                        .iter()
+                        .map(|(k, v)| (k.clone(), v.as_str().unwrap_or_default().to_string()))
+                        .collect()
+                }
+                None => return Ok(()),
+            }
+        }
+    }
+
+    /// Articles tagged by WikiProject `name`, according to PageAssessments
+    pub fn wikiproject_articles(&self, name: &str) -> Result<HashSet<String>, Error> {
+        let name = title::decode(name).replace('_', " ");
+        let mut articles = HashSet::new();
+
+        let params = [
+            ("action", "query"),
+            ("list", "projectpages"),
+            ("wppprojects", name.as_str()),
+            ("wpplimit", "max"),
+        ];
+        self.api_continued(&params, |res| {
+            let Some(pages) = res["query"]["projects"][&name].as_array() else {
+                return Err(format!("no WikiProject named {}", name).into());
+            };
+            articles.extend(
+                pages
+                    .iter()
+                    .filter(|p| p["ns"] == 0)
+                    .filter_map(|p| p["title"].as_str())
+                    .map(|t| title::encode(&title::underscored(t))),
+            );
+            Ok(())
+        })?;
+
+        Ok(articles)
+    }
+
+    /// Articles in `category` (`Category:Birds`), in URL form, not counting
+    /// those of its subcategories
+    pub fn category_members(&self, category: &str) -> Result<HashSet<String>, Error> {
+        let category = title::decode(category);
+        let mut members = HashSet::new();
+
+        let params = [
+            ("action", "query"),
+            ("list", "categorymembers"),
+            ("cmtitle", category.as_str()),
+            ("cmnamespace", "0"),
+            ("cmlimit", "max"),
+        ];
+        self.api_continued(&params, |res| {
+            let Some(pages) = res["query"]["categorymembers"].as_array() else {
+                return Err(format!("unexpected API response for {}", category).into());
+            };
+            members.extend(
+                pages
+                    .iter()
+                    .filter_map(|p| p["title"].as_str())
+                    .map(|t| title::encode(&title::underscored(t))),
+            );
+            Ok(())
+        })?;
+
+        Ok(members)
+    }
+
+    /// Visible categories of each of `titles`, following redirects. Titles
+    /// are in URL form, categories without the `Category:` prefix.
+    pub fn categories(&self, titles: &[&str]) -> Result<HashMap<String, Vec<String>>, Error> {
+        self.categories_where(titles, ("clshow", "!hidden"))
+    }
+
+    /// Which of `categories` (`Category:Stubs`), hidden or not, each of
+    /// `titles` is in, the same way as `categories`
+    pub fn categories_among(
+        &self,
+        titles: &[&str],
+        categories: &[String],
+    ) -> Result<HashMap<String, Vec<String>>, Error> {
+        let joined = categories
+            .iter()
+            .map(|c| title::decode(c))
+            .collect::<Vec<_>>()
+            .join("|");
+        self.categories_where(titles, ("clcategories", joined.as_str()))
+    }
+
+    /// Categories of each of `titles` that `filter`, a parameter of the
+    /// categories property, lets through
+    fn categories_where(
+        &self,
+        titles: &[&str],
+        filter: (&str, &str),
+    ) -> Result<HashMap<String, Vec<String>>, Error> {
+        let mut categories: HashMap<String, Vec<String>> = HashMap::new();
+
+        // The API takes at most 50 titles at once
+        for batch in titles.chunks(50) {
+            let decoded: Vec<String> = batch.iter().map(|t| title::decode(t)).collect();
+            let joined = decoded.join("|");
+            let params = [
+                ("action", "query"),
+                ("titles", joined.as_str()),
+                ("redirects", "1"),
+                ("prop", "categories"),
+                filter,
+                ("cllimit", "max"),
+            ];
+
+            let mut renamed: HashMap<String, String> = HashMap::new();
+            let mut found: HashMap<String, Vec<String>> = HashMap::new();
+            self.api_continued(&params, |res| {
+                for key in ["normalized", "redirects"] {
+                    for m in res["query"][key].as_array().into_iter().flatten() {
+                        if let (Some(from), Some(to)) = (m["from"].as_str(), m["to"].as_str()) {
+                            renamed.insert(from.to_string(), to.to_string());
+                        }
+                    }
+                }
+                for page in res["query"]["pages"].as_array().into_iter().flatten() {
+                    let Some(title) = page["title"].as_str() else {
+                        continue;
+                    };
+                    let cats = page["categories"].as_array().into_iter().flatten();
+                    found.entry(title.to_string()).or_default().extend(
+                        cats.filter_map(|c| c["title"].as_str())
+                            .map(|c| c.split_once(':').map_or(c, |(_, name)| name).to_string()),
+                    );
+                }
+                Ok(())
+            })?;
+
+            for (t, decoded) in batch.iter().zip(&decoded) {
+                let mut name = decoded.clone();
+                // Normalization, then a redirect
+                for _ in 0..2 {
+                    if let Some(to) = renamed.get(&name) {
+                        name = to.clone();
+                    }
+                }
+                categories.insert(t.to_string(), found.get(&name).cloned().unwrap_or_default());
+            }
+        }
+
+        Ok(categories)
+    }
+
+    /// Most articles worth fetching at once: one offline, as many as the
+    /// lanes may have in flight online
+    pub fn parallel_fetches(&self) -> usize {
+        match self.offline {
+            Some(_) => 1,
+            None => MAX_IN_FLIGHT * (1 + self.rest.is_some() as usize),
+        }
+    }
+
+    /// Minimum time between two requests
+    pub fn req_wait(&self) -> Duration {
+        self.lane.req_wait()
+    }
+
+    /// Log every request to `path`, one tab-separated line each
+    pub fn log_requests(&self, path: &Path) -> io::Result<()> {
+        let mut log = LineWriter::new(File::create(path)?);
+        writeln!(log, "time\turl\tstatus\tbytes\twait_ms\tlatency_ms")?;
+
+        *self.request_log.lock().unwrap() = Some(log);
+        Ok(())
+    }
+
+    /// Send a rate-limited request for the page `title`
+    fn get(&self, title: &str) -> Result<Response, Error> {
+        // Build request
+        let request = self.client.get(match &self.base {
+            Some(base) => format!("{}/wiki/{}", base, title),
+            None => self.project.page_url(title),
+        });
+
+        self.send(&self.lane, request)
+    }
+
+    fn send(&self, lane: &Lane, request: rw::blocking::RequestBuilder) -> Result<Response, Error> {
+        let request = match &lane.token {
+            Some(token) => request.bearer_auth(token),
+            None => request,
+        };
+        let request = request.build()?;
+        let url = request.url().to_string();
+
+        let ticket = self.timed(Phase::Waiting, || lane.wait());
+        let (wait, sent) = (ticket.wait, ticket.sent);
+
+        // Send request
+        let res = self.timed(Phase::Network, || {
+            self.client.execute(request).and_then(|res| {
+                let status = res.status();
+                let url = res.url().clone();
+                Ok(Response {
+                    url,
+                    status,
+                    body: res.text()?,
+                })
+            })
+        });
+        let latency = sent.elapsed();
+        SENT.with(|sent| sent.set(sent.get() + 1));
+
+        // Signs of the server being overloaded
+        let overloaded = match &res {
+            Ok(res) => {
+                res.status == rw::StatusCode::TOO_MANY_REQUESTS || res.status.is_server_error()
+            }
+            Err(_) => true,
+        };
+        lane.pacing.release(ticket, !overloaded);
+
+        self.timings.lock().unwrap().requests += 1;
+
+        self.audit("request", || {
+            let (status, bytes) = match &res {
+                Ok(res) => (Some(res.status.as_u16()), res.body.len()),
+                Err(err) => (err.status().map(|s| s.as_u16()), 0),
+            };
+            json!({
+                "url": url,
+                "status": status,
+                "bytes": bytes,
+                "error": res.as_ref().err().map(|err| err.to_string()),
+                "wait_ms": wait.as_millis() as u64,
+                "latency_ms": latency.as_millis() as u64,
+                "window": lane.pacing.window(),
+            })
+        });
+
+        let mut request_log = self.request_log.lock().unwrap();
+        if let Some(log) = request_log.as_mut() {
+            let (status, bytes) = match &res {
+                Ok(res) => (res.status.as_u16().to_string(), res.body.len()),
+                Err(err) => (
+                    err.status()
+                        .map_or("-".to_string(), |s| s.as_u16().to_string()),
+                    0,
+                ),
+            };
+            let time = jiff::Timestamp::now();
+            let logged = writeln!(
+                log,
+                "{time}\t{url}\t{status}\t{bytes}\t{}\t{}",
+                wait.as_millis(),
+                latency.as_millis(),
+            );
+            if let Err(err) = logged {
+                eprintln!("{}", err);
+                *request_log = None;
+            }
+        }
+
+        Ok(res?)
+    }
+}
+
+/// Markers of where the article proper ends: the reference list, external
+/// links and the navigation boxes after them only link to other articles
+/// loosely, and make up most of the links of long articles
+const CONTENT_END: &[&str] = &[
+    "id=\"References\"",
+    "id=\"External_links\"",
+    "class=\"navbox",
+];
+
+/// The part of an article's HTML before `CONTENT_END`, so the rest doesn't
+/// need to be parsed. The parser closes the elements left open.
+pub fn content(body: &str) -> &str {
+    let end = CONTENT_END
+        .iter()
+        .filter_map(|marker| body.find(marker))
+        .min()
+        .unwrap_or(body.len());
+    &body[..end]
+}
+
+/// The lead section of an article's HTML: from the start of the article text,
+/// past the page header and sidebars, to the first section heading
+pub fn lead(body: &str) -> &str {
+    // From the opening of the tag with the ID
+    let start = body
+        .find("id=\"mw-content-text\"")
+        .and_then(|idx| body[..idx].rfind('<'))
+        .unwrap_or(0);
+    let end = body[start..]
+        .find("<h2")
+        .map_or(body.len(), |idx| start + idx);
+    &body[start..end]
+}
+
+/// Links of a page saved outside the wiki, as in ZIM archives and Enterprise
+/// dumps, to the pages `keep` accepts. These are relative to the article (`Hamburg`,
+/// `./Hamburg` or `../A/Hamburg` in old archives) and may not be escaped the
+/// way MediaWiki does. Links with no name left, such as `./` or `?a=b`, are
+/// skipped.
+pub fn relative_links(
+    project: Project,
+    document: &sc::Html,
+    keep: impl Fn(&str) -> bool,
+) -> Vec<String> {
+    let selector = sc::Selector::parse("a[href]").unwrap();
+
+    let mut links = Vec::new();
+    for element in document.select(&selector) {
+        let Some(href) = element.value().attr("href") else {
+            continue;
+        };
+        let name = match project.link_name(href) {
+            // Links to the live wiki
+            Some(name) => name,
+            None => {
+                // Skip external, absolute, same-page and red links, and other
+                // schemes
+                let scheme = href.split_once(':').map(|(s, _)| s.to_ascii_lowercase());
+                if href.contains("redlink=1")
+                    || href.contains("://")
+                    || href.starts_with(['/', '#'])
+                    || matches!(
+                        scheme.as_deref(),
+                        Some("javascript" | "mailto" | "tel" | "data")
+                    )
+                {
+                    continue;
+                }
+
+                let name = href.strip_prefix("./").unwrap_or(href);
+                let name = name
+                    .strip_prefix("../A/")
+                    .or_else(|| name.strip_prefix("../C/"))
+                    .unwrap_or(name);
+                name.split(['#', '?']).next().unwrap_or("")
+            }
+        };
+
+        let name = title::encode(&title::decode(name));
+        if !name.is_empty() && keep(&name) {
+            links.push(name);
+        }
+    }
+
+    links
+}
+
+/// A fetched page
+struct Response {
+    /// Final URL, after redirects
+    url: rw::Url,
+    status: rw::StatusCode,
+    body: String,
+}
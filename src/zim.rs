@@ -0,0 +1,270 @@
+use std::{
+    fs::File,
+    io::{self, BufRead, BufReader, Read, Seek, SeekFrom},
+    path::Path,
+    sync::{Arc, Mutex},
+};
+
+const MAGIC: u32 = 72173914;
+
+/// Mimetype value marking a redirect entry
+const REDIRECT: u16 = 0xffff;
+
+/// A Kiwix ZIM archive, read in place.
+///
+/// See https://wiki.openzim.org/wiki/ZIM_file_format for the layout.
+pub struct Zim {
+    file: Mutex<File>,
+    entry_count: u32,
+    cluster_count: u32,
+    url_ptr_pos: u64,
+    cluster_ptr_pos: u64,
+    checksum_pos: u64,
+    /// Namespace holding the articles: `C` in current archives, `A` in old
+    namespace: u8,
+    html_mime: Option<u16>,
+    /// The most recently decompressed cluster, as articles that link to each
+    /// other are often stored together
+    last_cluster: Mutex<Option<(u32, Arc<Vec<u8>>)>>,
+}
+
+/// A directory entry
+struct Entry {
+    mime: u16,
+    namespace: u8,
+    /// Cluster and blob of an article, or the entry index of a redirect's
+    /// target in `cluster`
+    cluster: u32,
+    blob: u32,
+    url: Vec<u8>,
+}
+
+impl Zim {
+    pub fn open(path: &Path) -> io::Result<Self> {
+        let mut file = File::open(path)?;
+
+        let mut header = [0; 80];
+        file.read_exact(&mut header)?;
+        if u32_at(&header, 0) != MAGIC {
+            return Err(invalid("not a ZIM file"));
+        }
+        let minor = u16::from_le_bytes([header[6], header[7]]);
+
+        let mime_list_pos = u64_at(&header, 56);
+        file.seek(SeekFrom::Start(mime_list_pos))?;
+        let mut mimes = BufReader::new(&file);
+        let mut html_mime = None;
+        for idx in 0.. {
+            let mime = read_cstr(&mut mimes)?;
+            if mime.is_empty() {
+                break;
+            }
+            if mime == b"text/html" {
+                html_mime = Some(idx);
+            }
+        }
+
+        Ok(Zim {
+            file: Mutex::new(file),
+            entry_count: u32_at(&header, 24),
+            cluster_count: u32_at(&header, 28),
+            url_ptr_pos: u64_at(&header, 32),
+            cluster_ptr_pos: u64_at(&header, 48),
+            checksum_pos: u64_at(&header, 72),
+            namespace: if minor >= 1 { b'C' } else { b'A' },
+            html_mime,
+            last_cluster: Mutex::new(None),
+        })
+    }
+
+    /// HTML of the article at `url`, following redirects, or `None` if the
+    /// archive doesn't have it
+    pub fn article(&self, url: &str) -> io::Result<Option<String>> {
+        let Some(entry) = self.resolve(url)? else {
+            return Ok(None);
+        };
+        if Some(entry.mime) != self.html_mime {
+            return Ok(None);
+        }
+
+        let blob = self.blob(entry.cluster, entry.blob)?;
+        Ok(Some(String::from_utf8_lossy(&blob).into_owned()))
+    }
+
+    /// URL of the article `url` redirects to, or `url` itself
+    pub fn canonical(&self, url: &str) -> io::Result<Option<String>> {
+        Ok(self
+            .resolve(url)?
+            .map(|entry| String::from_utf8_lossy(&entry.url).into_owned()))
+    }
+
+    /// URL of the article entry at `idx` of the URL-ordered directory, if it
+    /// is one
+    pub fn article_url(&self, idx: u32) -> io::Result<Option<String>> {
+        let entry = self.entry(idx % self.entry_count.max(1))?;
+        if entry.namespace != self.namespace || Some(entry.mime) != self.html_mime {
+            return Ok(None);
+        }
+
+        Ok(Some(String::from_utf8_lossy(&entry.url).into_owned()))
+    }
+
+    /// Look up `url` in the article namespace and follow redirects
+    fn resolve(&self, url: &str) -> io::Result<Option<Entry>> {
+        let Some(mut entry) = self.find(url.as_bytes())? else {
+            return Ok(None);
+        };
+
+        // Bounded in case of redirect loops
+        for _ in 0..10 {
+            if entry.mime != REDIRECT {
+                return Ok(Some(entry));
+            }
+            entry = self.entry(entry.cluster)?;
+        }
+
+        Ok(None)
+    }
+
+    /// Binary search of the URL pointer list, which is sorted by namespace
+    /// then URL
+    fn find(&self, url: &[u8]) -> io::Result<Option<Entry>> {
+        let (mut lo, mut hi) = (0, self.entry_count);
+        while lo < hi {
+            let mid = lo + (hi - lo) / 2;
+            let entry = self.entry(mid)?;
+
+            match (entry.namespace, entry.url.as_slice()).cmp(&(self.namespace, url)) {
+                std::cmp::Ordering::Less => lo = mid + 1,
+                std::cmp::Ordering::Greater => hi = mid,
+                std::cmp::Ordering::Equal => return Ok(Some(entry)),
+            }
+        }
+
+        Ok(None)
+    }
+
+    fn entry(&self, idx: u32) -> io::Result<Entry> {
+        let mut file = self.file.lock().unwrap();
+
+        let ptr = read_u64(&mut file, self.url_ptr_pos + 8 * idx as u64)?;
+        file.seek(SeekFrom::Start(ptr))?;
+        let mut r = BufReader::new(&*file);
+
+        let mut fixed = [0; 16];
+        r.read_exact(&mut fixed[..12])?;
+        let mime = u16::from_le_bytes([fixed[0], fixed[1]]);
+        let namespace = fixed[3];
+        let cluster = u32_at(&fixed, 8);
+        let blob = if mime == REDIRECT {
+            0
+        } else {
+            r.read_exact(&mut fixed[12..])?;
+            u32_at(&fixed, 12)
+        };
+        let url = read_cstr(&mut r)?;
+
+        Ok(Entry {
+            mime,
+            namespace,
+            cluster,
+            blob,
+            url,
+        })
+    }
+
+    fn blob(&self, cluster: u32, blob: u32) -> io::Result<Vec<u8>> {
+        let data = self.cluster(cluster)?;
+
+        // Offsets are 8 bytes wide in extended clusters, 4 otherwise
+        let (info, data) = (data[0], &data[1..]);
+        let width = if info & 0x10 != 0 { 8 } else { 4 };
+        let offset = |n: usize| -> io::Result<usize> {
+            let bytes = data
+                .get(n * width..(n + 1) * width)
+                .ok_or_else(|| invalid("truncated cluster"))?;
+            let mut buf = [0; 8];
+            buf[..width].copy_from_slice(bytes);
+            Ok(u64::from_le_bytes(buf) as usize)
+        };
+
+        let (start, end) = (offset(blob as usize)?, offset(blob as usize + 1)?);
+        data.get(start..end)
+            .map(|b| b.to_vec())
+            .ok_or_else(|| invalid("blob out of cluster bounds"))
+    }
+
+    /// The info byte of cluster `idx` followed by its decompressed content
+    fn cluster(&self, idx: u32) -> io::Result<Arc<Vec<u8>>> {
+        if let Some((cached, data)) = &*self.last_cluster.lock().unwrap() {
+            if *cached == idx {
+                return Ok(data.clone());
+            }
+        }
+
+        let raw = {
+            let mut file = self.file.lock().unwrap();
+            let start = read_u64(&mut file, self.cluster_ptr_pos + 8 * idx as u64)?;
+            let end = if idx + 1 < self.cluster_count {
+                read_u64(&mut file, self.cluster_ptr_pos + 8 * (idx as u64 + 1))?
+            } else {
+                self.checksum_pos
+            };
+
+            let mut raw = vec![0; end.saturating_sub(start) as usize];
+            file.seek(SeekFrom::Start(start))?;
+            file.read_exact(&mut raw)?;
+            raw
+        };
+        let Some((&info, compressed)) = raw.split_first() else {
+            return Err(invalid("empty cluster"));
+        };
+
+        let mut data = vec![info];
+        match info & 0x0f {
+            0 | 1 => data.extend_from_slice(compressed),
+            4 => {
+                xz2::read::XzDecoder::new(compressed).read_to_end(&mut data)?;
+            }
+            5 => data.extend(zstd::stream::decode_all(compressed)?),
+            other => {
+                return Err(invalid(format!(
+                    "unsupported cluster compression {}",
+                    other
+                )))
+            }
+        }
+
+        let data = Arc::new(data);
+        *self.last_cluster.lock().unwrap() = Some((idx, data.clone()));
+        Ok(data)
+    }
+}
+
+fn invalid(msg: impl Into<String>) -> io::Error {
+    io::Error::new(io::ErrorKind::InvalidData, msg.into())
+}
+
+fn u32_at(buf: &[u8], at: usize) -> u32 {
+    u32::from_le_bytes(buf[at..at + 4].try_into().unwrap())
+}
+
+fn u64_at(buf: &[u8], at: usize) -> u64 {
+    u64::from_le_bytes(buf[at..at + 8].try_into().unwrap())
+}
+
+fn read_u64(file: &mut File, at: u64) -> io::Result<u64> {
+    let mut buf = [0; 8];
+    file.seek(SeekFrom::Start(at))?;
+    file.read_exact(&mut buf)?;
+    Ok(u64::from_le_bytes(buf))
+}
+
+fn read_cstr(r: &mut impl BufRead) -> io::Result<Vec<u8>> {
+    let mut s = Vec::new();
+    r.read_until(0, &mut s)?;
+    if s.pop() != Some(0) {
+        return Err(invalid("unterminated string"));
+    }
+    Ok(s)
+}